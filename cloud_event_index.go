@@ -88,6 +88,36 @@ func CloudEventToIndexKey(cloudHdr *cloudevent.CloudEventHeader) string {
 	return key
 }
 
+// IndexKeyToCloudEventHeader rebuilds the cloud event header an index key was created from with CloudEventToIndexKey.
+// Only the parts encoded in the key are set, so the ID, data content type and extras are empty and the time has second precision.
+// Subjects, producers and sources that are not encoded NFTDIDs or addresses are returned as stored in the key.
+func IndexKeyToCloudEventHeader(key string) (*cloudevent.CloudEventHeader, error) {
+	index, err := DecodeIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	subject := index.Subject
+	if did, err := DecodeNFTDIDIndex(subject); err == nil {
+		subject = did.String()
+	}
+	producer := index.Producer
+	if did, err := DecodeNFTDIDIndex(producer); err == nil {
+		producer = did.String()
+	}
+	source := index.Source
+	if addr, err := DecodeAddress(source); err == nil {
+		source = addr.Hex()
+	}
+	return &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        index.Timestamp,
+		Type:        FillerToCloudType(index.PrimaryFiller),
+		Source:      source,
+		Producer:    producer,
+		DataVersion: index.DataType,
+	}, nil
+}
+
 // EncodeAddress encodes an ethereum address without the 0x prefix.
 func EncodeAddress(address common.Address) string {
 	return address.Hex()[2:]
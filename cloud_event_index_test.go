@@ -335,3 +335,38 @@ func TestDecodeIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestIndexKeyToCloudEventHeader(t *testing.T) {
+	hdr := &cloudevent.CloudEventHeader{
+		ID: "ignored",
+		Subject: cloudevent.NFTDID{
+			ChainID:         153,
+			ContractAddress: common.HexToAddress("0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF"),
+			TokenID:         15,
+		}.String(),
+		Time:   time.Date(2024, 6, 11, 15, 30, 12, 500, time.UTC),
+		Type:   cloudevent.TypeStatus,
+		Source: "0x6C7cFb99AcfEFbA12DeD34387c11697061C196d0",
+		Producer: cloudevent.NFTDID{
+			ChainID:         153,
+			ContractAddress: common.HexToAddress("0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF"),
+			TokenID:         7,
+		}.String(),
+		DataVersion: "Stat_2.0.0",
+	}
+	expected := *hdr
+	expected.ID = ""
+	expected.Time = time.Date(2024, 6, 11, 15, 30, 12, 0, time.UTC)
+
+	result, err := IndexKeyToCloudEventHeader(CloudEventToIndexKey(hdr))
+	if err != nil {
+		t.Fatalf("IndexKeyToCloudEventHeader() error = %v", err)
+	}
+	if !reflect.DeepEqual(*result, expected) {
+		t.Fatalf("IndexKeyToCloudEventHeader() result = %+v, expected %+v", *result, expected)
+	}
+
+	if _, err := IndexKeyToCloudEventHeader("short"); err == nil {
+		t.Fatalf("IndexKeyToCloudEventHeader() error = nil, expected error")
+	}
+}
@@ -0,0 +1,93 @@
+// This is the nameindexer maintenance binary for operating on the cloud event index.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// storeS3 is the -store value that selects S3 with the default AWS configuration.
+const storeS3 = "s3"
+
+// command is a nameindexer subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{name: "schema-check", usage: "compare the live cloud_event table with the expected columns", run: runSchemaCheck},
+	{name: "retention", usage: "apply per event type retention as TTL rules", run: runRetention},
+	{name: "reconcile", usage: "compare the objects in a bucket with the index", run: runReconcile},
+	{name: "rebuild", usage: "rebuild the index from the objects in a bucket", run: runRebuild},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		if err := cmd.run(ctx, os.Args[2:]); err != nil {
+			stop()
+			log.Fatal(err)
+		}
+		return
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// openConn opens a clickhouse connection using the given DSN.
+func openConn(dsn string) (clickhouse.Conn, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("dsn is required")
+	}
+	opts, err := clickhouse.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DSN: %w", err)
+	}
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open clickhouse connection: %w", err)
+	}
+	return conn, nil
+}
+
+// openStore opens the blob store selected by the -store flag.
+// It is S3 with the default AWS configuration for "s3" and a local directory otherwise.
+func openStore(ctx context.Context, store string) (blobstore.BlobStore, error) {
+	if store == "" {
+		return nil, fmt.Errorf("store is required")
+	}
+	if store != storeS3 {
+		return blobstore.NewFilesystem(store), nil
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return blobstore.NewS3(s3.NewFromConfig(awsConfig)), nil
+}
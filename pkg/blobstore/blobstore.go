@@ -0,0 +1,56 @@
+// Package blobstore contains a storage agnostic interface for the objects that back cloud event indexes
+// with S3, filesystem and in-memory implementations.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidRange is returned when a requested byte range is not within the blob.
+var ErrInvalidRange = errors.New("invalid blob range")
+
+// checkRange returns ErrInvalidRange if the range is not within a blob of the given size.
+func checkRange(offset, length, size int64) error {
+	if offset < 0 || length <= 0 || offset > size || length > size-offset {
+		return fmt.Errorf("%w: %d bytes at offset %d of %d", ErrInvalidRange, length, offset, size)
+	}
+	return nil
+}
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	// Key is the key of the blob in its bucket.
+	Key string
+	// Size is the size of the blob in bytes.
+	Size int64
+	// ETag identifies the content of the blob. It changes whenever the blob is overwritten with different content.
+	ETag string
+	// LastModified is the time the blob was last written.
+	LastModified time.Time
+	// Metadata is the user metadata stored with the blob. It is not set by List.
+	Metadata map[string]string
+}
+
+// BlobStore stores blobs by bucket and key.
+type BlobStore interface {
+	// Get returns the content and info of the blob. It returns ErrNotFound if the blob does not exist.
+	Get(ctx context.Context, bucket, key string) ([]byte, BlobInfo, error)
+	// GetRange returns length bytes of the blob starting at offset and the info of the whole blob.
+	// It returns ErrNotFound if the blob does not exist and ErrInvalidRange if the range is not within the blob.
+	GetRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, BlobInfo, error)
+	// Put stores the blob with the given metadata, replacing any existing blob with the same key.
+	Put(ctx context.Context, bucket, key string, data []byte, metadata map[string]string) error
+	// Delete removes the blob. Deleting a blob that does not exist is not an error.
+	Delete(ctx context.Context, bucket, key string) error
+	// Head returns the info of the blob without its content. It returns ErrNotFound if the blob does not exist.
+	Head(ctx context.Context, bucket, key string) (BlobInfo, error)
+	// List returns the blobs whose key starts with prefix and sorts after startAfter, in ascending key order.
+	List(ctx context.Context, bucket, prefix, startAfter string) iter.Seq2[BlobInfo, error]
+}
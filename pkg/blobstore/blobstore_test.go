@@ -0,0 +1,121 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) blobstore.BlobStore{
+		"memory": func(*testing.T) blobstore.BlobStore { return blobstore.NewMemory() },
+		"filesystem": func(t *testing.T) blobstore.BlobStore {
+			return blobstore.NewFilesystem(t.TempDir())
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testBlobStore(t, newStore(t))
+		})
+	}
+}
+
+func testBlobStore(t *testing.T, store blobstore.BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+	const bucket = "test-bucket"
+
+	_, _, err := store.Get(ctx, bucket, "missing")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	_, err = store.Head(ctx, bucket, "missing")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	require.NoError(t, store.Delete(ctx, bucket, "missing"))
+
+	content := []byte(`{"vin": "1HGCM82633A123456"}`)
+	metadata := map[string]string{"content-encoding": "identity"}
+	require.NoError(t, store.Put(ctx, bucket, "a/b/1", content, metadata))
+	require.NoError(t, store.Put(ctx, bucket, "a/b/2", []byte("two"), nil))
+	require.NoError(t, store.Put(ctx, bucket, "a-c", []byte("three"), nil))
+	require.NoError(t, store.Put(ctx, bucket, "b", []byte("four"), nil))
+	require.NoError(t, store.Put(ctx, "other-bucket", "a/b/3", []byte("other"), nil))
+
+	data, info, err := store.Get(ctx, bucket, "a/b/1")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, "a/b/1", info.Key)
+	require.Equal(t, int64(len(content)), info.Size)
+	require.Equal(t, metadata, info.Metadata)
+	require.NotEmpty(t, info.ETag)
+	require.False(t, info.LastModified.IsZero())
+
+	head, err := store.Head(ctx, bucket, "a/b/1")
+	require.NoError(t, err)
+	require.Equal(t, info, head)
+
+	part, partInfo, err := store.GetRange(ctx, bucket, "a/b/1", 2, 3)
+	require.NoError(t, err)
+	require.Equal(t, content[2:5], part)
+	require.Equal(t, info, partInfo)
+	_, _, err = store.GetRange(ctx, bucket, "a/b/1", int64(len(content))-1, 2)
+	require.ErrorIs(t, err, blobstore.ErrInvalidRange)
+	_, _, err = store.GetRange(ctx, bucket, "a/b/1", -1, 2)
+	require.ErrorIs(t, err, blobstore.ErrInvalidRange)
+	_, _, err = store.GetRange(ctx, bucket, "missing", 0, 1)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	// overwriting with different content changes the ETag
+	require.NoError(t, store.Put(ctx, bucket, "a/b/1", []byte("changed"), nil))
+	head, err = store.Head(ctx, bucket, "a/b/1")
+	require.NoError(t, err)
+	require.NotEqual(t, info.ETag, head.ETag)
+	require.Empty(t, head.Metadata)
+
+	listKeys := func(prefix, startAfter string) []string {
+		var keys []string
+		for info, err := range store.List(ctx, bucket, prefix, startAfter) {
+			require.NoError(t, err)
+			keys = append(keys, info.Key)
+		}
+		return keys
+	}
+	require.Equal(t, []string{"a-c", "a/b/1", "a/b/2", "b"}, listKeys("", ""))
+	require.Equal(t, []string{"a-c", "a/b/1", "a/b/2"}, listKeys("a", ""))
+	require.Equal(t, []string{"a/b/2", "b"}, listKeys("", "a/b/1"))
+	require.Empty(t, listKeys("z", ""))
+
+	require.NoError(t, store.Delete(ctx, bucket, "a/b/1"))
+	_, _, err = store.Get(ctx, bucket, "a/b/1")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	require.Equal(t, []string{"a-c", "a/b/2", "b"}, listKeys("", ""))
+
+	for range store.List(ctx, "empty-bucket", "", "") {
+		t.Fatal("expected no blobs in an empty bucket")
+	}
+}
+
+func TestFilesystemStoreRejectsUnsafeKeys(t *testing.T) {
+	store := blobstore.NewFilesystem(t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"", "../escape", "/abs", "a/../../b", "dir/"} {
+		require.Error(t, store.Put(ctx, "bucket", key, []byte("x"), nil), key)
+	}
+	require.Error(t, store.Put(ctx, ".metadata", "key", []byte("x"), nil))
+}
+
+type invalidRangeS3Client struct {
+	blobstore.S3Client
+}
+
+func (invalidRangeS3Client) GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "InvalidRange", Message: "The requested range is not satisfiable"}
+}
+
+func TestS3StoreInvalidRange(t *testing.T) {
+	store := blobstore.NewS3(invalidRangeS3Client{})
+	_, _, err := store.GetRange(context.Background(), "bucket", "key", 100, 10)
+	require.ErrorIs(t, err, blobstore.ErrInvalidRange)
+}
@@ -0,0 +1,263 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// metadataDir is the directory under the root that holds the metadata sidecars.
+	// Bucket names can not start with a dot so it can not collide with a bucket.
+	metadataDir = ".metadata"
+	// tempPattern is the pattern of the temporary files used for atomic writes.
+	tempPattern = ".blob-*"
+)
+
+// FilesystemStore is a BlobStore that stores each blob as a file under root/bucket/key.
+// Metadata and ETags are kept in a JSON sidecar under root/.metadata/bucket/key.json.
+type FilesystemStore struct {
+	root string
+}
+
+// sidecar is the content of a metadata sidecar file.
+type sidecar struct {
+	ETag     string            `json:"etag"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// NewFilesystem creates a BlobStore that stores blobs in the given directory.
+func NewFilesystem(root string) *FilesystemStore {
+	return &FilesystemStore{root: root}
+}
+
+// Get returns the content and info of the blob.
+func (f *FilesystemStore) Get(_ context.Context, bucket, key string) ([]byte, BlobInfo, error) {
+	dataPath, metaPath, err := f.paths(bucket, key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to read blob '%s': %w", key, fsNotFound(err))
+	}
+	info, err := f.info(key, dataPath, metaPath)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return data, info, nil
+}
+
+// GetRange reads the requested range of the blob from its file.
+func (f *FilesystemStore) GetRange(_ context.Context, bucket, key string, offset, length int64) ([]byte, BlobInfo, error) {
+	dataPath, metaPath, err := f.paths(bucket, key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to open blob '%s': %w", key, fsNotFound(err))
+	}
+	defer file.Close() //nolint // read only
+	info, err := f.info(key, dataPath, metaPath)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	if err := checkRange(offset, length, info.Size); err != nil {
+		return nil, BlobInfo{}, err
+	}
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to read blob '%s': %w", key, err)
+	}
+	return data, info, nil
+}
+
+// Put stores the blob with the given metadata.
+// The content is written to a temporary file and renamed so readers never see a partial blob.
+func (f *FilesystemStore) Put(_ context.Context, bucket, key string, data []byte, metadata map[string]string) error {
+	dataPath, metaPath, err := f.paths(bucket, key)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(sidecar{ETag: etag(data), Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("failed to marshal blob metadata: %w", err)
+	}
+	if err := writeFileAtomic(metaPath, meta); err != nil {
+		return fmt.Errorf("failed to write metadata for blob '%s': %w", key, err)
+	}
+	if err := writeFileAtomic(dataPath, data); err != nil {
+		return fmt.Errorf("failed to write blob '%s': %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the blob and its metadata.
+func (f *FilesystemStore) Delete(_ context.Context, bucket, key string) error {
+	dataPath, metaPath, err := f.paths(bucket, key)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{dataPath, metaPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete blob '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Head returns the info of the blob without its content.
+func (f *FilesystemStore) Head(_ context.Context, bucket, key string) (BlobInfo, error) {
+	dataPath, metaPath, err := f.paths(bucket, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return f.info(key, dataPath, metaPath)
+}
+
+// List returns the blobs whose key starts with prefix and sorts after startAfter.
+// The bucket directory is walked before the first blob is returned.
+func (f *FilesystemStore) List(ctx context.Context, bucket, prefix, startAfter string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		if err := validateBucket(bucket); err != nil {
+			yield(BlobInfo{}, err)
+			return
+		}
+		bucketDir := filepath.Join(f.root, bucket)
+		var keys []string
+		err := filepath.WalkDir(bucketDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return ctx.Err()
+			}
+			if matched, _ := filepath.Match(tempPattern, entry.Name()); matched {
+				return nil
+			}
+			rel, err := filepath.Rel(bucketDir, path)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			if strings.HasPrefix(key, prefix) && key > startAfter {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			yield(BlobInfo{}, fmt.Errorf("failed to list blobs: %w", err))
+			return
+		}
+		// the walk is lexical per directory which differs from key order when keys contain slashes.
+		slices.Sort(keys)
+		for _, key := range keys {
+			info, err := f.Head(ctx, bucket, key)
+			if errors.Is(err, ErrNotFound) {
+				// deleted since the walk
+				continue
+			}
+			if err != nil {
+				yield(BlobInfo{}, err)
+				return
+			}
+			info.Metadata = nil
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// info returns the info of the blob from its file and metadata sidecar.
+func (f *FilesystemStore) info(key, dataPath, metaPath string) (BlobInfo, error) {
+	stat, err := os.Stat(dataPath)
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to stat blob '%s': %w", key, fsNotFound(err))
+	}
+	info := BlobInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}
+	meta, err := os.ReadFile(metaPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// blobs copied into the directory by hand have no sidecar
+		data, err := os.ReadFile(dataPath)
+		if err != nil {
+			return BlobInfo{}, fmt.Errorf("failed to read blob '%s': %w", key, fsNotFound(err))
+		}
+		info.ETag = etag(data)
+		return info, nil
+	}
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to read metadata for blob '%s': %w", key, err)
+	}
+	var side sidecar
+	if err := json.Unmarshal(meta, &side); err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to unmarshal metadata for blob '%s': %w", key, err)
+	}
+	info.ETag = side.ETag
+	info.Metadata = side.Metadata
+	return info, nil
+}
+
+// paths returns the data and metadata sidecar paths of the blob.
+func (f *FilesystemStore) paths(bucket, key string) (string, string, error) {
+	if err := validateBucket(bucket); err != nil {
+		return "", "", err
+	}
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("invalid blob key %q", key)
+	}
+	rel := filepath.FromSlash(key)
+	return filepath.Join(f.root, bucket, rel), filepath.Join(f.root, metadataDir, bucket, rel+".json"), nil
+}
+
+// validateBucket returns an error if the bucket name can not be used as a directory name.
+func validateBucket(bucket string) error {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the file through a temporary file in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, tempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint // the file is already renamed on success
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fsNotFound wraps file not found errors with ErrNotFound.
+func fsNotFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // used for ETags like S3, not for security
+	"encoding/hex"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a BlobStore that keeps blobs in memory. It is intended for tests and local development.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data []byte
+	info BlobInfo
+}
+
+// NewMemory creates an empty in-memory BlobStore.
+func NewMemory() *MemoryStore {
+	return &MemoryStore{buckets: map[string]map[string]memoryBlob{}}
+}
+
+// Get returns the content and info of the blob.
+func (m *MemoryStore) Get(_ context.Context, bucket, key string) ([]byte, BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, BlobInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return slices.Clone(blob.data), cloneInfo(blob.info), nil
+}
+
+// GetRange returns a copy of the requested range of the blob.
+func (m *MemoryStore) GetRange(_ context.Context, bucket, key string, offset, length int64) ([]byte, BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, BlobInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err := checkRange(offset, length, blob.info.Size); err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return slices.Clone(blob.data[offset : offset+length]), cloneInfo(blob.info), nil
+}
+
+// Put stores the blob with the given metadata.
+func (m *MemoryStore) Put(_ context.Context, bucket, key string, data []byte, metadata map[string]string) error {
+	blob := memoryBlob{
+		data: slices.Clone(data),
+		info: BlobInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ETag:         etag(data),
+			LastModified: time.Now(),
+			Metadata:     maps.Clone(metadata),
+		},
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buckets[bucket] == nil {
+		m.buckets[bucket] = map[string]memoryBlob{}
+	}
+	m.buckets[bucket][key] = blob
+	return nil
+}
+
+// Delete removes the blob.
+func (m *MemoryStore) Delete(_ context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+// Head returns the info of the blob without its content.
+func (m *MemoryStore) Head(_ context.Context, bucket, key string) (BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.buckets[bucket][key]
+	if !ok {
+		return BlobInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return cloneInfo(blob.info), nil
+}
+
+// List returns the blobs whose key starts with prefix and sorts after startAfter.
+// The listing is a snapshot taken when iteration starts.
+func (m *MemoryStore) List(ctx context.Context, bucket, prefix, startAfter string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		m.mu.RLock()
+		var infos []BlobInfo
+		for key, blob := range m.buckets[bucket] {
+			if strings.HasPrefix(key, prefix) && key > startAfter {
+				info := blob.info
+				info.Metadata = nil
+				infos = append(infos, info)
+			}
+		}
+		m.mu.RUnlock()
+		slices.SortFunc(infos, func(a, b BlobInfo) int { return strings.Compare(a.Key, b.Key) })
+		for _, info := range infos {
+			if err := ctx.Err(); err != nil {
+				yield(BlobInfo{}, err)
+				return
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// cloneInfo returns a copy of the info that does not share its metadata map.
+func cloneInfo(info BlobInfo) BlobInfo {
+	info.Metadata = maps.Clone(info.Metadata)
+	return info
+}
+
+// etag returns an S3 style ETag for the content.
+func etag(data []byte) string {
+	sum := md5.Sum(data) //nolint:gosec // used for ETags like S3, not for security
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
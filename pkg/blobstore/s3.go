@@ -0,0 +1,213 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// S3Client is the subset of the S3 client used by S3Store.
+type S3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// S3Store is a BlobStore backed by S3.
+type S3Store struct {
+	client S3Client
+}
+
+// NewS3 creates a BlobStore that stores blobs in S3 using the given client.
+func NewS3(client S3Client) *S3Store {
+	return &S3Store{client: client}
+}
+
+// Get returns the content and info of the object.
+func (s *S3Store) Get(ctx context.Context, bucket, key string) ([]byte, BlobInfo, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to get object '%s' from S3: %w", key, notFound(err))
+	}
+	defer obj.Body.Close() //nolint
+
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to read object body: %w", err)
+	}
+	info := BlobInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		ETag:         aws.ToString(obj.ETag),
+		LastModified: aws.ToTime(obj.LastModified),
+		Metadata:     obj.Metadata,
+	}
+	return data, info, nil
+}
+
+// GetRange returns the requested range of the object using a ranged GET.
+func (s *S3Store) GetRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, BlobInfo, error) {
+	if offset < 0 || length <= 0 {
+		return nil, BlobInfo{}, fmt.Errorf("%w: %d bytes at offset %d", ErrInvalidRange, length, offset)
+	}
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to get object '%s' range from S3: %w", key, invalidRange(notFound(err)))
+	}
+	defer obj.Body.Close() //nolint
+
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("failed to read object body: %w", err)
+	}
+	// S3 returns a shorter body instead of an error when the range runs past the end of the object.
+	if int64(len(data)) != length {
+		return nil, BlobInfo{}, fmt.Errorf("%w: got %d of %d bytes at offset %d", ErrInvalidRange, len(data), length, offset)
+	}
+	info := BlobInfo{
+		Key:          key,
+		Size:         rangeTotalSize(aws.ToString(obj.ContentRange)),
+		ETag:         aws.ToString(obj.ETag),
+		LastModified: aws.ToTime(obj.LastModified),
+		Metadata:     obj.Metadata,
+	}
+	return data, info, nil
+}
+
+// Put stores the object with the given metadata.
+func (s *S3Store) Put(ctx context.Context, bucket, key string, data []byte, metadata map[string]string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(data),
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store object '%s' in S3: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the object.
+func (s *S3Store) Delete(ctx context.Context, bucket, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object '%s' from S3: %w", key, err)
+	}
+	return nil
+}
+
+// Head returns the info of the object without its content.
+func (s *S3Store) Head(ctx context.Context, bucket, key string) (BlobInfo, error) {
+	obj, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to head object '%s' in S3: %w", key, notFound(err))
+	}
+	return BlobInfo{
+		Key:          key,
+		Size:         aws.ToInt64(obj.ContentLength),
+		ETag:         aws.ToString(obj.ETag),
+		LastModified: aws.ToTime(obj.LastModified),
+		Metadata:     obj.Metadata,
+	}, nil
+}
+
+// List returns the objects whose key starts with prefix and sorts after startAfter.
+// S3 returns keys in ascending UTF-8 binary order.
+func (s *S3Store) List(ctx context.Context, bucket, prefix, startAfter string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+		}
+		if prefix != "" {
+			input.Prefix = aws.String(prefix)
+		}
+		if startAfter != "" {
+			input.StartAfter = aws.String(startAfter)
+		}
+		for {
+			page, err := s.client.ListObjectsV2(ctx, input)
+			if err != nil {
+				yield(BlobInfo{}, fmt.Errorf("failed to list objects in S3: %w", err))
+				return
+			}
+			for _, obj := range page.Contents {
+				info := BlobInfo{
+					Key:          aws.ToString(obj.Key),
+					Size:         aws.ToInt64(obj.Size),
+					ETag:         aws.ToString(obj.ETag),
+					LastModified: aws.ToTime(obj.LastModified),
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+			if !aws.ToBool(page.IsTruncated) || page.NextContinuationToken == nil {
+				return
+			}
+			input.ContinuationToken = page.NextContinuationToken
+		}
+	}
+}
+
+// rangeTotalSize returns the total object size from a Content-Range header such as "bytes 0-9/1234".
+// It returns -1 when the size is unknown.
+func rangeTotalSize(contentRange string) int64 {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// notFound wraps S3 not found errors with ErrNotFound.
+func notFound(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFoundErr *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFoundErr) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// invalidRange wraps S3 invalid range errors with ErrInvalidRange.
+func invalidRange(err error) error {
+	var apiErr smithy.APIError
+	var respErr *smithyhttp.ResponseError
+	if (errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange") ||
+		(errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable) {
+		return fmt.Errorf("%w: %w", ErrInvalidRange, err)
+	}
+	return err
+}
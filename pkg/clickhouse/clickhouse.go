@@ -1,6 +1,8 @@
 package clickhouse
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -12,6 +14,9 @@ import (
 const (
 	// TableName is the name of the table in Clickhouse.
 	TableName = "cloud_event"
+	// LatestTableName is the name of the table holding the latest cloud event per subject, type, source and data version.
+	// It is filled by a materialized view on TableName.
+	LatestTableName = "cloud_event_latest"
 	// SubjectColumn is the name of the subject column in Clickhouse.
 	SubjectColumn = "subject"
 	// TimestampColumn is the name of the timestamp column in Clickhouse.
@@ -30,6 +35,21 @@ const (
 	DataVersionColumn = "data_version"
 	// ExtraColumn is the name of the extra column in Clickhouse.
 	ExtrasColumn = "extras"
+	// ExtrasMapColumn is the name of the column holding the extras as a queryable map in Clickhouse.
+	ExtrasMapColumn = "extras_map"
+	// DataOffsetColumn is the name of the column holding the byte offset of the cloud event in its backing object.
+	DataOffsetColumn = "data_offset"
+	// DataLengthColumn is the name of the column holding the byte length of the cloud event in its backing object.
+	// A length of zero means the cloud event is the whole object.
+	DataLengthColumn = "data_length"
+	// DataFormatColumn is the name of the column holding the format of the backing object.
+	// An empty format means the object or its byte range holds the raw data.
+	DataFormatColumn = "data_format"
+	// DataRowGroupColumn is the name of the column holding the row group of the cloud event in an archive object.
+	DataRowGroupColumn = "data_row_group"
+	// DataChecksumColumn is the name of the column holding the hex encoded SHA-256 of the cloud event payload.
+	// An empty checksum means the payload was stored before checksums were recorded.
+	DataChecksumColumn = "data_checksum"
 	// IndexKeyColumn is the name of the index name column in Clickhouse.
 	IndexKeyColumn = "index_key"
 
@@ -44,10 +64,51 @@ const (
 		DataContentTypeColumn + ", " +
 		DataVersionColumn + ", " +
 		ExtrasColumn + ", " +
+		ExtrasMapColumn + ", " +
+		DataOffsetColumn + ", " +
+		DataLengthColumn + ", " +
+		DataFormatColumn + ", " +
+		DataRowGroupColumn + ", " +
+		DataChecksumColumn + ", " +
 		IndexKeyColumn +
-		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	// DataFormatParquet is the data format of cloud events archived in a Parquet file.
+	DataFormatParquet = "parquet"
+
+	// legacyCloudEventSliceLength is the length of cloud event slices created before the extras map column existed.
+	legacyCloudEventSliceLength = 10
+	// wholeObjectCloudEventSliceLength is the length of cloud event slices created before the object range columns existed.
+	wholeObjectCloudEventSliceLength = 11
+	// rawObjectCloudEventSliceLength is the length of cloud event slices created before the archive columns existed.
+	rawObjectCloudEventSliceLength = 13
+	// uncheckedCloudEventSliceLength is the length of cloud event slices created before the checksum column existed.
+	uncheckedCloudEventSliceLength = 15
 )
 
+// ObjectRef locates the data of a cloud event in its backing object.
+type ObjectRef struct {
+	// Key is the key of the backing object.
+	Key string
+	// Offset is the byte offset of the cloud event in the object.
+	Offset uint64
+	// Length is the byte length of the cloud event in the object. Zero means the whole object.
+	// For archive objects Offset is the row of the cloud event in its row group and Length is unused.
+	Length uint64
+	// Format is the format of the object, empty for raw data or DataFormatParquet for archives.
+	Format string
+	// RowGroup is the row group of the cloud event in an archive object.
+	RowGroup uint32
+	// Checksum is the hex encoded SHA-256 of the cloud event payload, see DataChecksum.
+	Checksum string
+}
+
+// DataChecksum returns the hex encoded SHA-256 of the given payload as stored in the data_checksum column.
+func DataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // CloudEventToSlice converts a CloudEvent to an array of any for Clickhouse insertion.
 // The order of the elements in the array match the order of the columns in the table.
 func CloudEventToSlice(event *cloudevent.CloudEventHeader) []any {
@@ -57,6 +118,12 @@ func CloudEventToSlice(event *cloudevent.CloudEventHeader) []any {
 // CloudEventToSlice converts a CloudEvent to an array of any for Clickhouse insertion.
 // The order of the elements in the array match the order of the columns in the table.
 func CloudEventToSliceWithKey(event *cloudevent.CloudEventHeader, key string) []any {
+	return CloudEventToSliceWithRef(event, ObjectRef{Key: key})
+}
+
+// CloudEventToSliceWithRef converts a CloudEvent stored at the given location to an array of any for Clickhouse insertion.
+// The order of the elements in the array match the order of the columns in the table.
+func CloudEventToSliceWithRef(event *cloudevent.CloudEventHeader, ref ObjectRef) []any {
 	jsonExtra, _ := json.Marshal(event.Extras)
 	return []any{
 		event.Subject,
@@ -68,10 +135,44 @@ func CloudEventToSliceWithKey(event *cloudevent.CloudEventHeader, key string) []
 		event.DataContentType,
 		event.DataVersion,
 		string(jsonExtra),
-		key,
+		ExtrasToMap(event.Extras),
+		ref.Offset,
+		ref.Length,
+		ref.Format,
+		ref.RowGroup,
+		ref.Checksum,
+		ref.Key,
 	}
 }
 
+// ExtrasToMap converts cloud event extras to the values stored in the extras map column.
+// String values are stored as is and all other values are stored as JSON.
+func ExtrasToMap(extras map[string]any) map[string]string {
+	extrasMap := make(map[string]string, len(extras))
+	for k, v := range extras {
+		if str, ok := v.(string); ok {
+			extrasMap[k] = str
+			continue
+		}
+		jsonVal, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		extrasMap[k] = string(jsonVal)
+	}
+	return extrasMap
+}
+
+// extrasStringToMap converts a marshalled extras string to the values stored in the extras map column.
+// Strings that are not a JSON object result in an empty map.
+func extrasStringToMap(extras string) map[string]string {
+	var extrasObj map[string]any
+	if err := json.Unmarshal([]byte(extras), &extrasObj); err != nil {
+		return map[string]string{}
+	}
+	return ExtrasToMap(extrasObj)
+}
+
 // IndexToSlice converts a Inedx to an array of any for Clickhouse insertion.
 // The order of the elements in the array match the order of the columns in the table.
 func IndexToSlice(origIndex *nameindexer.Index) ([]any, error) {
@@ -89,13 +190,19 @@ func IndexToSliceWithKey(index *nameindexer.Index, key string) []any {
 		index.Subject,   // Vehicle or Device DID
 		index.Timestamp, // Timestamp
 		nameindexer.FillerToCloudType(index.PrimaryFiller), // DIMO event type (status, fingerprint, connectivity)
-		"",                 // Event ID
-		index.Source,       // Source Ethereum address
-		index.Producer,     // Producer DID
-		"application/json", // DataContentType
-		index.DataType,     // DataVersion
-		index.Optional,     // Extra metadata
-		key,                // Index key
+		"",                                // Event ID
+		index.Source,                      // Source Ethereum address
+		index.Producer,                    // Producer DID
+		"application/json",                // DataContentType
+		index.DataType,                    // DataVersion
+		index.Optional,                    // Extra metadata
+		extrasStringToMap(index.Optional), // Extra metadata map
+		uint64(0),                         // Data offset
+		uint64(0),                         // Data length
+		"",                                // Data format
+		uint32(0),                         // Data row group
+		"",                                // Data checksum
+		key,                               // Index key
 	}
 }
 
@@ -158,13 +265,17 @@ func UnmarshalIndexSlice(jsonArray []byte) ([]any, error) {
 }
 
 // UnmarshalCloudEventSlice unmarshals a byte slice into an array of any for Clickhouse insertion.
+// Slices created before the extras map column existed are accepted and the map is derived from the extras.
+// Slices created before the object range, archive or checksum columns existed are accepted and refer to the raw object.
 func UnmarshalCloudEventSlice(jsonArray []byte) ([]any, error) {
 	rawSlice := []json.RawMessage{}
 	err := json.Unmarshal(jsonArray, &rawSlice)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal cloud event slice: %w", err)
 	}
-	if len(rawSlice) != 10 {
+	switch len(rawSlice) {
+	case len(Columns), uncheckedCloudEventSliceLength, rawObjectCloudEventSliceLength, wholeObjectCloudEventSliceLength, legacyCloudEventSliceLength:
+	default:
 		return nil, fmt.Errorf("invalid cloud event slice length: %d", len(rawSlice))
 	}
 	var subject string
@@ -176,6 +287,12 @@ func UnmarshalCloudEventSlice(jsonArray []byte) ([]any, error) {
 	var dataContentType string
 	var dataVersion string
 	var extras string
+	var extrasMap map[string]string
+	var dataOffset uint64
+	var dataLength uint64
+	var dataFormat string
+	var dataRowGroup uint32
+	var dataChecksum string
 	var indexKey string
 	err = json.Unmarshal(rawSlice[0], &subject)
 	if err != nil {
@@ -213,9 +330,46 @@ func UnmarshalCloudEventSlice(jsonArray []byte) ([]any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal extras: %w", err)
 	}
-	err = json.Unmarshal(rawSlice[9], &indexKey)
+	if len(rawSlice) == legacyCloudEventSliceLength {
+		extrasMap = extrasStringToMap(extras)
+	} else {
+		err = json.Unmarshal(rawSlice[9], &extrasMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal extras map: %w", err)
+		}
+		if extrasMap == nil {
+			extrasMap = map[string]string{}
+		}
+	}
+	if len(rawSlice) >= rawObjectCloudEventSliceLength {
+		err = json.Unmarshal(rawSlice[10], &dataOffset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data offset: %w", err)
+		}
+		err = json.Unmarshal(rawSlice[11], &dataLength)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data length: %w", err)
+		}
+	}
+	if len(rawSlice) >= uncheckedCloudEventSliceLength {
+		err = json.Unmarshal(rawSlice[12], &dataFormat)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data format: %w", err)
+		}
+		err = json.Unmarshal(rawSlice[13], &dataRowGroup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data row group: %w", err)
+		}
+	}
+	if len(rawSlice) == len(Columns) {
+		err = json.Unmarshal(rawSlice[14], &dataChecksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data checksum: %w", err)
+		}
+	}
+	err = json.Unmarshal(rawSlice[len(rawSlice)-1], &indexKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal index key: %w", err)
 	}
-	return []any{subject, timestamp, eventType, id, source, producer, dataContentType, dataVersion, extras, indexKey}, nil
+	return []any{subject, timestamp, eventType, id, source, producer, dataContentType, dataVersion, extras, extrasMap, dataOffset, dataLength, dataFormat, dataRowGroup, dataChecksum, indexKey}, nil
 }
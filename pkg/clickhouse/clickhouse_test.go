@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
 	"github.com/DIMO-Network/nameindexer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -192,4 +193,121 @@ func TestIndexToSliceWithKey_RoundTrip(t *testing.T) {
 
 	// Verify the key matches
 	assert.Equal(t, key, recoveredSlice[len(recoveredSlice)-1])
+	assert.Equal(t, map[string]string{}, recoveredSlice[9])
+}
+
+func TestIndexToSliceWithKey_ExtrasMapRoundTrip(t *testing.T) {
+	index := &nameindexer.Index{
+		Subject:       "did:dimo:vehicle123",
+		Timestamp:     time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		PrimaryFiller: "status",
+		Source:        "0x123",
+		DataType:      "1.0",
+		Producer:      "did:dimo:producer456",
+		Optional:      `{"vin":"1HGCM82633A123456","count":3}`,
+	}
+	slice := IndexToSliceWithKey(index, "index_key_789")
+	want := map[string]string{"vin": "1HGCM82633A123456", "count": "3"}
+	assert.Equal(t, want, slice[9])
+
+	jsonData, err := json.Marshal(slice)
+	require.NoError(t, err)
+	recoveredSlice, err := UnmarshalCloudEventSlice(jsonData)
+	require.NoError(t, err)
+	assert.Equal(t, index.Optional, recoveredSlice[8])
+	assert.Equal(t, want, recoveredSlice[9])
+}
+
+func TestExtrasToMap(t *testing.T) {
+	extras := map[string]any{
+		"vin":   "1HGCM82633A123456",
+		"count": 3,
+		"valid": true,
+		"tags":  []string{"a", "b"},
+	}
+	got := ExtrasToMap(extras)
+	assert.Equal(t, map[string]string{
+		"vin":   "1HGCM82633A123456",
+		"count": "3",
+		"valid": "true",
+		"tags":  `["a","b"]`,
+	}, got)
+	assert.Equal(t, map[string]string{}, ExtrasToMap(nil))
+}
+
+func TestUnmarshalCloudEventSlice_Legacy(t *testing.T) {
+	testTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	legacy := []any{
+		"did:dimo:vehicle123",
+		testTime,
+		"dimo.status",
+		"id",
+		"0x123",
+		"did:dimo:producer456",
+		"application/json",
+		"1.0",
+		`{"vin":"1HGCM82633A123456"}`,
+		"index_key_789",
+	}
+	jsonData, err := json.Marshal(legacy)
+	require.NoError(t, err)
+
+	got, err := UnmarshalCloudEventSlice(jsonData)
+	require.NoError(t, err)
+	require.Len(t, got, len(Columns))
+	assert.Equal(t, map[string]string{"vin": "1HGCM82633A123456"}, got[9])
+	assert.Equal(t, "index_key_789", got[len(got)-1])
+}
+
+func TestCloudEventToSliceWithRef_RoundTrip(t *testing.T) {
+	event := &cloudevent.CloudEventHeader{
+		Subject:     "did:dimo:vehicle123",
+		Time:        time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Type:        "dimo.status",
+		DataVersion: "1.0",
+	}
+	ref := ObjectRef{Key: "batch_key", Offset: 1024, Length: 256, Format: DataFormatParquet, RowGroup: 3, Checksum: DataChecksum([]byte("{}"))}
+	slice := CloudEventToSliceWithRef(event, ref)
+	require.Len(t, slice, len(Columns))
+
+	jsonData, err := json.Marshal(slice)
+	require.NoError(t, err)
+	got, err := UnmarshalCloudEventSlice(jsonData)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024), got[10])
+	assert.Equal(t, uint64(256), got[11])
+	assert.Equal(t, DataFormatParquet, got[12])
+	assert.Equal(t, uint32(3), got[13])
+	assert.Equal(t, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", got[14])
+	assert.Equal(t, "batch_key", got[len(got)-1])
+
+	// slices created before the object range columns existed refer to the whole object
+	jsonData, err = json.Marshal(append(slice[:10:10], "whole_key"))
+	require.NoError(t, err)
+	got, err = UnmarshalCloudEventSlice(jsonData)
+	require.NoError(t, err)
+	require.Len(t, got, len(Columns))
+	assert.Equal(t, uint64(0), got[10])
+	assert.Equal(t, uint64(0), got[11])
+	assert.Equal(t, "", got[12])
+	assert.Equal(t, "", got[14])
+	assert.Equal(t, "whole_key", got[len(got)-1])
+}
+
+func TestCloudEventToSliceWithRef_EmptyID(t *testing.T) {
+	event := &cloudevent.CloudEventHeader{
+		ID:          "event-1",
+		Subject:     "did:dimo:vehicle123",
+		Time:        time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Type:        cloudevent.TypeStatus,
+		DataVersion: "1.0",
+	}
+	assert.Equal(t, "event-1", CloudEventToSliceWithRef(event, ObjectRef{Key: "batch_key"})[3])
+
+	// events without an ID keep it empty, the table engine tells them apart by their index key
+	event.ID = ""
+	assert.Equal(t, "", CloudEventToSliceWithRef(event, ObjectRef{Key: "batch_key"})[3])
+
+	index := &nameindexer.Index{Subject: "did:dimo:vehicle123", PrimaryFiller: "status"}
+	assert.Equal(t, "", IndexToSliceWithKey(index, "index_key_789")[3])
 }
@@ -0,0 +1,147 @@
+package indexrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// ErrInvalidColumn is returned when a column can not be used for grouping or listing distinct values.
+var ErrInvalidColumn = errors.New("invalid column")
+
+// groupByColumns are the columns indexes can be grouped by or listed distinct values of.
+var groupByColumns = map[string]struct{}{
+	chindexer.SubjectColumn:     {},
+	chindexer.TypeColumn:        {},
+	chindexer.SourceColumn:      {},
+	chindexer.ProducerColumn:    {},
+	chindexer.DataVersionColumn: {},
+}
+
+const (
+	countAlias  = "event_count"
+	bucketAlias = "time_bucket"
+)
+
+// HistogramBucket is the number of indexes in a single group and time bucket.
+type HistogramBucket struct {
+	// Start is the start of the time bucket. It is zero when the histogram is not bucketed by time.
+	Start time.Time
+	// Group holds the value of each group by column keyed by column name.
+	Group map[string]string
+	// Count is the number of indexes in the bucket.
+	Count uint64
+}
+
+// CountIndexes returns the number of indexes that match the given options.
+func (s *Service) CountIndexes(ctx context.Context, opts *SearchOptions) (uint64, error) {
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	mods := []qm.QueryMod{
+		qm.Select("count() AS " + countAlias),
+		qm.From(fromTable(opts)),
+	}
+	optsMods, err := opts.QueryMods()
+	if err != nil {
+		return 0, err
+	}
+	mods = append(mods, optsMods...)
+	query, args := newQuery(mods...)
+	var count uint64
+	if err := s.chConn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count cloud events: %w", err)
+	}
+	return count, nil
+}
+
+// Histogram returns the number of indexes that match the given options grouped by the given columns
+// and by time buckets of the given size. Group by columns must be one of subject, event_type, source, producer or data_version.
+// A bucket of zero disables grouping by time. Buckets are aligned to the Unix epoch and must be whole seconds.
+// The result is ordered by bucket start and then by group values.
+func (s *Service) Histogram(ctx context.Context, opts *SearchOptions, groupBy []string, bucket time.Duration) ([]HistogramBucket, error) {
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := buildHistogramQuery(opts, groupBy, bucket)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.chConn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud event histogram: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+
+	var buckets []HistogramBucket
+	groupValues := make([]string, len(groupBy))
+	for rows.Next() {
+		var histBucket HistogramBucket
+		dest := []any{&histBucket.Count}
+		if bucket > 0 {
+			dest = append(dest, &histBucket.Start)
+		}
+		for i := range groupValues {
+			dest = append(dest, &groupValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan cloud event histogram: %w", err)
+		}
+		histBucket.Group = make(map[string]string, len(groupBy))
+		for i, column := range groupBy {
+			histBucket.Group[column] = groupValues[i]
+		}
+		buckets = append(buckets, histBucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over cloud event histogram: %w", err)
+	}
+	return buckets, nil
+}
+
+// buildHistogramQuery builds the query used by Histogram.
+func buildHistogramQuery(opts *SearchOptions, groupBy []string, bucket time.Duration) (string, []any, error) {
+	if bucket < 0 || bucket%time.Second != 0 {
+		return "", nil, fmt.Errorf("histogram bucket must be zero or a positive number of whole seconds, got %s", bucket)
+	}
+	selects := []string{"count() AS " + countAlias}
+	var groups []string
+	if bucket > 0 {
+		selects = append(selects, intervalExpr(bucket)+" AS "+bucketAlias)
+		groups = append(groups, bucketAlias)
+	}
+	for _, column := range groupBy {
+		if _, ok := groupByColumns[column]; !ok {
+			return "", nil, fmt.Errorf("%w: can not group by %q", ErrInvalidColumn, column)
+		}
+		selects = append(selects, column)
+		groups = append(groups, column)
+	}
+	mods := []qm.QueryMod{
+		qm.Select(selects...),
+		qm.From(fromTable(opts)),
+	}
+	for _, group := range groups {
+		mods = append(mods, qm.GroupBy(group), qm.OrderBy(group))
+	}
+	optsMods, err := opts.QueryMods()
+	if err != nil {
+		return "", nil, err
+	}
+	mods = append(mods, optsMods...)
+	query, args := newQuery(mods...)
+	return query, args, nil
+}
+
+// intervalExpr returns the expression for the start of the time bucket of the given size.
+func intervalExpr(bucket time.Duration) string {
+	seconds := strconv.FormatInt(int64(bucket/time.Second), 10)
+	return "toStartOfInterval(" + chindexer.TimestampColumn + ", INTERVAL " + seconds + " SECOND)"
+}
@@ -0,0 +1,68 @@
+package indexrepo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHistogram tests counting indexes grouped by columns and time buckets.
+func TestHistogram(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	events := []cloudevent.CloudEventHeader{
+		{Subject: subject, Time: start, Type: cloudevent.TypeStatus, DataVersion: dataType},
+		{Subject: subject, Time: start.Add(10 * time.Minute), Type: cloudevent.TypeStatus, DataVersion: dataType},
+		{Subject: subject, Time: start.Add(20 * time.Minute), Type: cloudevent.TypeFingerprint, DataVersion: dataType},
+		{Subject: subject, Time: start.Add(70 * time.Minute), Type: cloudevent.TypeStatus, DataVersion: dataType},
+	}
+	for i := range events {
+		_ = insertTestData(t, ctx, conn, &events[i])
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	opts := &indexrepo.SearchOptions{Subject: &subject}
+
+	count, err := indexService.CountIndexes(ctx, opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), count)
+
+	buckets, err := indexService.Histogram(ctx, opts, []string{chindexer.TypeColumn}, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []indexrepo.HistogramBucket{
+		{Start: start, Group: map[string]string{chindexer.TypeColumn: cloudevent.TypeFingerprint}, Count: 1},
+		{Start: start, Group: map[string]string{chindexer.TypeColumn: cloudevent.TypeStatus}, Count: 2},
+		{Start: start.Add(time.Hour), Group: map[string]string{chindexer.TypeColumn: cloudevent.TypeStatus}, Count: 1},
+	}, buckets)
+
+	buckets, err = indexService.Histogram(ctx, opts, []string{chindexer.SubjectColumn}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []indexrepo.HistogramBucket{
+		{Group: map[string]string{chindexer.SubjectColumn: subject}, Count: 4},
+	}, buckets)
+}
+
+func TestHistogramInvalidArgs(t *testing.T) {
+	indexService := indexrepo.New(nil, nil)
+	_, err := indexService.Histogram(context.Background(), nil, []string{chindexer.ExtrasColumn}, 0)
+	require.ErrorIs(t, err, indexrepo.ErrInvalidColumn)
+	_, err = indexService.Histogram(context.Background(), nil, nil, time.Millisecond)
+	require.ErrorContains(t, err, "zero or a positive number of whole seconds")
+	_, err = indexService.Histogram(context.Background(), nil, nil, -time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,487 @@
+package indexrepo
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// ArchiveKeySuffix is appended to the index key of the first cloud event to form the key of an archive object.
+// It is followed by a hash of the archive content so archives starting with the same cloud event do not collide.
+const ArchiveKeySuffix = "archive-"
+
+// archiveRowGroupSize is the number of cloud events in each row group of an archive.
+const archiveRowGroupSize = 1000
+
+// archiveRow is a single cloud event in an archive object.
+// The columns mirror the cloud_event table with the payload in the data column.
+type archiveRow struct {
+	Subject         string    `parquet:"subject"`
+	Time            time.Time `parquet:"event_time,timestamp(millisecond)"`
+	Type            string    `parquet:"event_type"`
+	ID              string    `parquet:"id"`
+	Source          string    `parquet:"source"`
+	Producer        string    `parquet:"producer"`
+	DataContentType string    `parquet:"data_content_type"`
+	DataVersion     string    `parquet:"data_version"`
+	Extras          string    `parquet:"extras"`
+	Data            []byte    `parquet:"data"`
+}
+
+// ArchiveResult is the outcome of archiving the cloud events of a subject.
+type ArchiveResult struct {
+	// Key is the key of the archive object. It is empty when there was nothing to archive.
+	Key string
+	// Events is the number of cloud events in the archive.
+	Events int
+	// ReplacedKeys are the keys of the objects that only backed the archived cloud events.
+	// The index rows that pointed at them are deleted, but other rows may still point at the same objects,
+	// so only delete the keys returned by UnreferencedKeys.
+	// Batch objects are not listed because they may hold other cloud events.
+	ReplacedKeys []string
+}
+
+// ArchiveSubjectDay compacts the cloud events of the subject on the UTC day of the given time into a single Parquet object
+// and re-points their indexes at the row of each cloud event in the archive.
+// The replaced index rows are deleted from the cloud event and latest tables once the archive indexes are stored,
+// so queries without deduplication never return a cloud event twice or point at a replaced object.
+// Cloud events that are already archived are skipped so archiving a day again only picks up cloud events indexed since.
+func (s *Service) ArchiveSubjectDay(ctx context.Context, bucketName, subject string, day time.Time) (ArchiveResult, error) {
+	day = day.UTC()
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	opts := &SearchOptions{
+		Subject:        &subject,
+		After:          start,
+		AfterInclusive: true,
+		Before:         start.AddDate(0, 0, 1),
+		TimestampAsc:   true,
+		Deduplicate:    true,
+	}
+	var indexes []cloudevent.CloudEvent[ObjectInfo]
+	for index, err := range s.IterIndexes(ctx, 0, opts) {
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+		if index.Data.Format == chindexer.DataFormatParquet {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return ArchiveResult{}, nil
+	}
+
+	refs := make([]ObjectInfo, len(indexes))
+	for i := range indexes {
+		refs[i] = indexes[i].Data
+	}
+	objects, _, err := s.fetchObjects(ctx, bucketName, refs, false)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	rows := make([]archiveRow, len(indexes))
+	for i := range indexes {
+		rows[i], err = newArchiveRow(&indexes[i].CloudEventHeader, objects[indexes[i].Data])
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+	}
+	archive, archiveRefs, err := writeArchive(rows, archiveRowGroupSize)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	sum := sha256.Sum256(archive)
+	key := nameindexer.CloudEventToIndexKey(&indexes[0].CloudEventHeader) + ArchiveKeySuffix + hex.EncodeToString(sum[:8])
+	if err := s.store.Put(ctx, bucketName, key, archive, withChecksumMetadata(nil, chindexer.DataChecksum(archive))); err != nil {
+		return ArchiveResult{}, fmt.Errorf("failed to store archive object: %w", err)
+	}
+
+	archiveIndexes := make([]cloudevent.CloudEvent[chindexer.ObjectRef], len(indexes))
+	for i := range indexes {
+		archiveRefs[i].Key = key
+		archiveIndexes[i] = cloudevent.CloudEvent[chindexer.ObjectRef]{CloudEventHeader: indexes[i].CloudEventHeader, Data: archiveRefs[i]}
+	}
+	if err := s.InsertIndexes(ctx, archiveIndexes); err != nil {
+		return ArchiveResult{}, err
+	}
+
+	result := ArchiveResult{Key: key, Events: len(indexes)}
+	var indexKeys []string
+	seen := make(map[string]struct{}, len(indexes))
+	for i := range indexes {
+		ref := indexes[i].Data
+		if _, ok := seen[ref.Key]; ok {
+			continue
+		}
+		seen[ref.Key] = struct{}{}
+		indexKeys = append(indexKeys, ref.Key)
+		if ref.Length == 0 {
+			result.ReplacedKeys = append(result.ReplacedKeys, ref.Key)
+		}
+	}
+	if err := s.deleteReplacedIndexes(ctx, subject, opts.After, opts.Before, indexKeys); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// deleteReplacedIndexes deletes the index rows of the subject in [start, end) that point at the given keys
+// from the cloud event and latest tables and waits until the deletes are applied.
+func (s *Service) deleteReplacedIndexes(ctx context.Context, subject string, start, end time.Time, keys []string) error {
+	placeholders := make([]string, len(keys))
+	args := []any{subject, start, end}
+	for i, key := range keys {
+		placeholders[i] = "?"
+		args = append(args, key)
+	}
+	where := chindexer.SubjectColumn + " = ? AND " + chindexer.TimestampColumn + " >= ? AND " + chindexer.TimestampColumn + " < ? AND " +
+		chindexer.IndexKeyColumn + " IN (" + strings.Join(placeholders, ", ") + ")"
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"mutations_sync": 2}))
+	for _, table := range []string{chindexer.TableName, chindexer.LatestTableName} {
+		if err := s.chConn.Exec(ctx, "ALTER TABLE "+table+" DELETE WHERE "+where, args...); err != nil {
+			return fmt.Errorf("failed to delete replaced indexes from %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// UnreferencedKeys returns the given keys that no row of the cloud event or latest table points at, in the order they were given.
+// Rows are read without deduplication, so a key is not returned while any row that was not merged away yet points at it.
+func (s *Service) UnreferencedKeys(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	placeholders := make([]string, len(keys))
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		placeholders[i] = "?"
+		args[i] = key
+	}
+	referenced := make(map[string]struct{}, len(keys))
+	for _, table := range []string{chindexer.TableName, chindexer.LatestTableName} {
+		query, queryArgs := newQuery(
+			qm.Select("DISTINCT "+chindexer.IndexKeyColumn),
+			qm.From(table),
+			qm.Where(chindexer.IndexKeyColumn+" IN ("+strings.Join(placeholders, ", ")+")", args...),
+		)
+		if err := s.scanIndexKeys(ctx, query, queryArgs, referenced); err != nil {
+			return nil, err
+		}
+	}
+	var unreferenced []string
+	for _, key := range keys {
+		if _, ok := referenced[key]; !ok {
+			unreferenced = append(unreferenced, key)
+		}
+	}
+	return unreferenced, nil
+}
+
+// scanIndexKeys runs the query selecting index keys and adds them to the set.
+func (s *Service) scanIndexKeys(ctx context.Context, query string, args []any, keys map[string]struct{}) error {
+	rows, err := s.chConn.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to get referenced index keys: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return fmt.Errorf("failed to scan referenced index key: %w", err)
+		}
+		keys[key] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate over referenced index keys: %w", err)
+	}
+	return nil
+}
+
+// newArchiveRow creates the archive row for the cloud event with the given header and payload.
+func newArchiveRow(hdr *cloudevent.CloudEventHeader, data []byte) (archiveRow, error) {
+	row := archiveRow{
+		Subject:         hdr.Subject,
+		Time:            hdr.Time.UTC(),
+		Type:            hdr.Type,
+		ID:              hdr.ID,
+		Source:          hdr.Source,
+		Producer:        hdr.Producer,
+		DataContentType: hdr.DataContentType,
+		DataVersion:     hdr.DataVersion,
+		Data:            data,
+	}
+	if len(hdr.Extras) > 0 {
+		extras, err := json.Marshal(hdr.Extras)
+		if err != nil {
+			return archiveRow{}, fmt.Errorf("failed to marshal extras of cloud event '%s': %w", hdr.ID, err)
+		}
+		row.Extras = string(extras)
+	}
+	return row, nil
+}
+
+// writeArchive writes the rows to a Parquet file with row groups of the given size.
+// It returns the file and the position of each row in it.
+func writeArchive(rows []archiveRow, rowGroupSize int) ([]byte, []chindexer.ObjectRef, error) {
+	var buf bytes.Buffer
+	writer := parquet.NewGenericWriter[archiveRow](&buf,
+		parquet.MaxRowsPerRowGroup(int64(rowGroupSize)),
+		parquet.Compression(&zstd.Codec{}),
+	)
+	refs := make([]chindexer.ObjectRef, len(rows))
+	for start := 0; start < len(rows); start += rowGroupSize {
+		end := min(start+rowGroupSize, len(rows))
+		if _, err := writer.Write(rows[start:end]); err != nil {
+			return nil, nil, fmt.Errorf("failed to write archive rows: %w", err)
+		}
+		// Every row group is flushed explicitly so the row group of each row is known.
+		if err := writer.Flush(); err != nil {
+			return nil, nil, fmt.Errorf("failed to flush archive row group: %w", err)
+		}
+		for i := start; i < end; i++ {
+			refs[i] = chindexer.ObjectRef{
+				Offset:   uint64(i - start),
+				Format:   chindexer.DataFormatParquet,
+				RowGroup: uint32(start / rowGroupSize),
+				Checksum: chindexer.DataChecksum(rows[i].Data),
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to close archive: %w", err)
+	}
+	return buf.Bytes(), refs, nil
+}
+
+// readArchiveRow reads the payload of the cloud event the object info points to from an archive object.
+// Only the pages of the data column in the row group are read from the blob store, the footer is read once per opened archive.
+// It returns the payload and the info of the archive.
+func (s *Service) readArchiveRow(ctx context.Context, bucketName string, ref ObjectInfo) ([]byte, blobstore.BlobInfo, error) {
+	archive, err := s.openArchive(ctx, bucketName, ref.Key)
+	if err != nil {
+		return nil, blobstore.BlobInfo{}, err
+	}
+	info := archive.info
+	rowGroups := archive.file.RowGroups()
+	if int(ref.RowGroup) >= len(rowGroups) {
+		return nil, info, fmt.Errorf("failed to read archive '%s': row group %d out of range", ref.Key, ref.RowGroup)
+	}
+	rowGroup := rowGroups[ref.RowGroup]
+	if ref.Offset >= uint64(rowGroup.NumRows()) {
+		return nil, info, fmt.Errorf("failed to read archive '%s': row %d out of range in row group %d", ref.Key, ref.Offset, ref.RowGroup)
+	}
+	pages := rowGroup.ColumnChunks()[archive.dataColumn].Pages()
+	defer pages.Close() //nolint // we are not interested in the error here
+	if err := pages.SeekToRow(int64(ref.Offset)); err != nil {
+		return nil, info, fmt.Errorf("failed to seek archive '%s': %w", ref.Key, err)
+	}
+	page, err := pages.ReadPage()
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to read archive '%s': %w", ref.Key, err)
+	}
+	defer parquet.Release(page)
+	values := make([]parquet.Value, 1)
+	n, err := page.Values().ReadValues(values)
+	if n == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, info, fmt.Errorf("failed to read archive '%s': %w", ref.Key, err)
+	}
+	return bytes.Clone(values[0].ByteArray()), info, nil
+}
+
+// openArchive returns the opened archive object from the archive file cache or opens it by reading its footer.
+// The pages of an opened archive are read without the cancellation of the context it was opened with,
+// because the archive is shared by later reads.
+func (s *Service) openArchive(ctx context.Context, bucketName, key string) (*openedArchive, error) {
+	if archive, ok := s.archives.get(bucketName, key); ok {
+		return archive, nil
+	}
+	info, err := s.store.Head(ctx, bucketName, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	readerAt := &blobReaderAt{ctx: context.WithoutCancel(ctx), service: s, bucketName: bucketName, key: key, size: info.Size}
+	file, err := parquet.OpenFile(readerAt, info.Size, parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive '%s': %w", key, err)
+	}
+	dataColumn, ok := file.Schema().Lookup("data")
+	if !ok {
+		return nil, fmt.Errorf("failed to open archive '%s': no data column", key)
+	}
+	archive := &openedArchive{file: file, info: info, dataColumn: dataColumn.ColumnIndex}
+	s.archives.add(bucketName, key, archive)
+	return archive, nil
+}
+
+// archiveFileCacheSize is the number of opened archives a service keeps.
+const archiveFileCacheSize = 64
+
+// openedArchive is an archive object whose footer was read.
+type openedArchive struct {
+	file       *parquet.File
+	info       blobstore.BlobInfo
+	dataColumn int
+}
+
+// archiveFileCache keeps the most recently used opened archives by bucket and key.
+// Archive keys contain a hash of their content, so an opened archive stays valid until its object is deleted.
+type archiveFileCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[lruKey]*list.Element
+}
+
+type archiveFileEntry struct {
+	key     lruKey
+	archive *openedArchive
+}
+
+func newArchiveFileCache(size int) *archiveFileCache {
+	return &archiveFileCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[lruKey]*list.Element{},
+	}
+}
+
+// get returns the opened archive and marks it as recently used.
+func (c *archiveFileCache) get(bucket, key string) (*openedArchive, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[lruKey{bucket: bucket, key: key}]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*archiveFileEntry).archive, true
+}
+
+// add stores the opened archive and evicts the least recently used archive when the cache is full.
+func (c *archiveFileCache) add(bucket, key string, archive *openedArchive) {
+	cacheKey := lruKey{bucket: bucket, key: key}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[cacheKey]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[cacheKey] = c.order.PushFront(&archiveFileEntry{key: cacheKey, archive: archive})
+	if c.order.Len() > c.size {
+		entry := c.order.Remove(c.order.Back()).(*archiveFileEntry)
+		delete(c.entries, entry.key)
+	}
+}
+
+// delete removes the opened archive.
+func (c *archiveFileCache) delete(bucket, key string) {
+	cacheKey := lruKey{bucket: bucket, key: key}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[cacheKey]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, cacheKey)
+	}
+}
+
+// archiveIndexes returns the index of every cloud event in the archive.
+// The headers are rebuilt from the archive columns and the refs have no key.
+func archiveIndexes(archive []byte) ([]cloudevent.CloudEvent[chindexer.ObjectRef], error) {
+	file, err := parquet.OpenFile(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	var indexes []cloudevent.CloudEvent[chindexer.ObjectRef]
+	for group, rowGroup := range file.RowGroups() {
+		rows := make([]archiveRow, rowGroup.NumRows())
+		reader := parquet.NewGenericRowGroupReader[archiveRow](rowGroup)
+		n, err := reader.Read(rows)
+		reader.Close() //nolint // we are not interested in the error here
+		if n < len(rows) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed to read archive row group %d: %w", group, err)
+		}
+		for offset := range rows {
+			row := &rows[offset]
+			hdr := cloudevent.CloudEventHeader{
+				ID:              row.ID,
+				Source:          row.Source,
+				Producer:        row.Producer,
+				Subject:         row.Subject,
+				Time:            row.Time,
+				Type:            row.Type,
+				DataContentType: row.DataContentType,
+				DataVersion:     row.DataVersion,
+			}
+			if row.Extras != "" {
+				if err := json.Unmarshal([]byte(row.Extras), &hdr.Extras); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal extras of cloud event '%s': %w", row.ID, err)
+				}
+			}
+			indexes = append(indexes, cloudevent.CloudEvent[chindexer.ObjectRef]{
+				CloudEventHeader: hdr,
+				Data: chindexer.ObjectRef{
+					Offset:   uint64(offset),
+					Format:   chindexer.DataFormatParquet,
+					RowGroup: uint32(group),
+					Checksum: chindexer.DataChecksum(row.Data),
+				},
+			})
+		}
+	}
+	return indexes, nil
+}
+
+// blobReaderAt reads an object from the blob store with ranged gets.
+type blobReaderAt struct {
+	ctx        context.Context
+	service    *Service
+	bucketName string
+	key        string
+	size       int64
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), r.size-off)
+	if length == 0 {
+		return 0, nil
+	}
+	data, _, err := r.service.store.GetRange(r.ctx, r.bucketName, r.key, off, length)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive range: %w", err)
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
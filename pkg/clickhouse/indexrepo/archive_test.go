@@ -0,0 +1,186 @@
+package indexrepo_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetObjectFromRefArchive(t *testing.T) {
+	ctx := context.Background()
+	payloads := make([][]byte, 7)
+	for i := range payloads {
+		payloads[i] = []byte(fmt.Sprintf(`{"speed":%d}`, i))
+	}
+	archive, refs, err := indexrepo.WriteArchive(payloads, 3)
+	require.NoError(t, err)
+	require.Len(t, refs, len(payloads))
+	require.Equal(t, uint32(2), refs[6].RowGroup)
+	require.Equal(t, uint64(0), refs[6].Offset)
+
+	store := blobstore.NewMemory()
+	require.NoError(t, store.Put(ctx, "bucket", "archive", archive, nil))
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), false))
+
+	// read in reverse so rows are not only read in file order
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := indexrepo.ObjectInfo{Key: "archive", Offset: refs[i].Offset, Format: refs[i].Format, RowGroup: refs[i].RowGroup}
+		data, err := indexService.GetObjectFromRef(ctx, ref, "bucket")
+		require.NoError(t, err)
+		require.Equal(t, string(payloads[i]), string(data))
+	}
+	// the cached rows do not shadow each other
+	data, err := indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "archive", Offset: 1, Format: chindexer.DataFormatParquet, RowGroup: 1}, "bucket")
+	require.NoError(t, err)
+	require.Equal(t, string(payloads[4]), string(data))
+
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "archive", Format: chindexer.DataFormatParquet, RowGroup: 3}, "bucket")
+	require.Error(t, err)
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "archive", Offset: 1, Format: chindexer.DataFormatParquet, RowGroup: 2}, "bucket")
+	require.Error(t, err)
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "archive", Format: "avro"}, "bucket")
+	require.Error(t, err)
+}
+
+// headCountingStore wraps a blob store to count heads and ranged gets.
+type headCountingStore struct {
+	blobstore.BlobStore
+	heads     atomic.Int32
+	rangeGets atomic.Int32
+}
+
+func (h *headCountingStore) Head(ctx context.Context, bucket, key string) (blobstore.BlobInfo, error) {
+	h.heads.Add(1)
+	return h.BlobStore.Head(ctx, bucket, key)
+}
+
+func (h *headCountingStore) GetRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, blobstore.BlobInfo, error) {
+	h.rangeGets.Add(1)
+	return h.BlobStore.GetRange(ctx, bucket, key, offset, length)
+}
+
+func TestGetObjectFromRefArchiveOpenedOnce(t *testing.T) {
+	ctx := context.Background()
+	payloads := make([][]byte, 7)
+	for i := range payloads {
+		payloads[i] = []byte(fmt.Sprintf(`{"speed":%d}`, i))
+	}
+	archive, refs, err := indexrepo.WriteArchive(payloads, 3)
+	require.NoError(t, err)
+	store := &headCountingStore{BlobStore: blobstore.NewMemory()}
+	require.NoError(t, store.Put(ctx, "bucket", "archive", archive, nil))
+	indexService := indexrepo.NewWithBlobStore(nil, store)
+
+	ref := indexrepo.ObjectInfo{Key: "archive", Offset: refs[0].Offset, Format: refs[0].Format, RowGroup: refs[0].RowGroup}
+	_, err = indexService.GetObjectFromRef(ctx, ref, "bucket")
+	require.NoError(t, err)
+	openGets := store.rangeGets.Load()
+	for i := range refs {
+		ref := indexrepo.ObjectInfo{Key: "archive", Offset: refs[i].Offset, Format: refs[i].Format, RowGroup: refs[i].RowGroup}
+		data, err := indexService.GetObjectFromRef(ctx, ref, "bucket")
+		require.NoError(t, err)
+		require.Equal(t, string(payloads[i]), string(data))
+	}
+	require.Equal(t, int32(1), store.heads.Load())
+	// later reads only fetch the data column page of the row, not the footer or other columns
+	require.Equal(t, int32(len(refs)), store.rangeGets.Load()-openGets)
+}
+
+func TestUnreferencedKeys(t *testing.T) {
+	ctx := context.Background()
+	indexService := indexrepo.NewWithBlobStore(&keyConn{keys: []string{"b"}}, blobstore.NewMemory())
+	keys, err := indexService.UnreferencedKeys(ctx, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keys)
+
+	keys, err = indexService.UnreferencedKeys(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestArchiveSubjectDay(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+	day := time.Now().UTC().AddDate(0, 0, -2).Truncate(24 * time.Hour)
+	var headers []cloudevent.CloudEventHeader
+	for i := range 4 {
+		hdr := cloudevent.CloudEventHeader{
+			ID:          fmt.Sprintf("event-%d", i),
+			Subject:     subject,
+			Time:        day.Add(time.Duration(i) * time.Hour),
+			Type:        cloudevent.TypeStatus,
+			DataVersion: dataType,
+		}
+		require.NoError(t, indexService.StoreObject(ctx, "test-bucket", &hdr, []byte(fmt.Sprintf(`{"speed":%d}`, i))))
+		headers = append(headers, hdr)
+	}
+	// an event on the next day is not archived
+	nextDay := cloudevent.CloudEventHeader{ID: "next", Subject: subject, Time: day.AddDate(0, 0, 1), Type: cloudevent.TypeFingerprint, DataVersion: dataType}
+	require.NoError(t, indexService.StoreObject(ctx, "test-bucket", &nextDay, []byte(`{"speed":9}`)))
+
+	result, err := indexService.ArchiveSubjectDay(ctx, "test-bucket", subject, day.Add(12*time.Hour))
+	require.NoError(t, err)
+	require.Contains(t, result.Key, indexrepo.ArchiveKeySuffix)
+	require.Equal(t, len(headers), result.Events)
+	require.Len(t, result.ReplacedKeys, len(headers))
+
+	// the replaced rows are gone without deduplication or merges
+	opts := &indexrepo.SearchOptions{Subject: &subject, TimestampAsc: true}
+	indexes, err := indexService.ListIndexes(ctx, 10, opts)
+	require.NoError(t, err)
+	require.Len(t, indexes, len(headers)+1)
+	for i := range headers {
+		require.Equal(t, result.Key, indexes[i].Data.Key)
+		require.Equal(t, chindexer.DataFormatParquet, indexes[i].Data.Format)
+	}
+	require.Empty(t, indexes[len(headers)].Data.Format)
+
+	unreferenced, err := indexService.UnreferencedKeys(ctx, result.ReplacedKeys)
+	require.NoError(t, err)
+	require.ElementsMatch(t, result.ReplacedKeys, unreferenced)
+
+	// the old objects are no longer needed to read the archived cloud events
+	for _, key := range unreferenced {
+		require.NoError(t, store.Delete(ctx, "test-bucket", key))
+	}
+	events, err := indexService.ListCloudEventsFromIndexes(ctx, indexes, "test-bucket")
+	require.NoError(t, err)
+	for i := range headers {
+		require.Equal(t, headers[i].ID, events[i].ID)
+		require.JSONEq(t, fmt.Sprintf(`{"speed":%d}`, i), string(events[i].Data))
+	}
+
+	// the latest table points at the archive as well
+	latestOpts := &indexrepo.SearchOptions{Subject: &subject, Type: ref(cloudevent.TypeStatus)}
+	require.True(t, indexrepo.LatestTableCompatible(latestOpts))
+	latest, err := indexService.GetLatestIndex(ctx, latestOpts)
+	require.NoError(t, err)
+	require.Equal(t, result.Key, latest.Data.Key)
+	event, err := indexService.GetLatestCloudEvent(ctx, "test-bucket", latestOpts)
+	require.NoError(t, err)
+	require.Equal(t, headers[len(headers)-1].ID, event.ID)
+
+	// archiving the day again finds nothing new
+	result, err = indexService.ArchiveSubjectDay(ctx, "test-bucket", subject, day)
+	require.NoError(t, err)
+	require.Empty(t, result.Key)
+}
@@ -0,0 +1,74 @@
+package indexrepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+)
+
+// BatchKeySuffix is appended to the index key of the first cloud event to form the key of a batch object.
+// It is followed by a hash of the batch content so batches starting with the same cloud event do not collide.
+const BatchKeySuffix = "batch-"
+
+// StoreCloudEventBatch stores the cloud events as a single NDJSON object and indexes every cloud event
+// with its byte range in the object so it can be read back with a ranged get.
+// Batch objects are never compressed because compressed ranges can not be read on their own.
+// It returns the key of the batch object.
+func (s *Service) StoreCloudEventBatch(ctx context.Context, bucketName string, events []cloudevent.CloudEvent[json.RawMessage]) (string, error) {
+	if len(events) == 0 {
+		return "", errors.New("no cloud events to store")
+	}
+	var buf bytes.Buffer
+	refs := make([]chindexer.ObjectRef, len(events))
+	for i := range events {
+		line, err := json.Marshal(events[i])
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal cloud event %d: %w", i, err)
+		}
+		refs[i] = chindexer.ObjectRef{Offset: uint64(buf.Len()), Length: uint64(len(line)), Checksum: chindexer.DataChecksum(line)}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	key := nameindexer.CloudEventToIndexKey(&events[0].CloudEventHeader) + BatchKeySuffix + hex.EncodeToString(sum[:8])
+
+	if err := s.store.Put(ctx, bucketName, key, buf.Bytes(), withChecksumMetadata(nil, chindexer.DataChecksum(buf.Bytes()))); err != nil {
+		return "", fmt.Errorf("failed to store batch object: %w", err)
+	}
+
+	indexes := make([]cloudevent.CloudEvent[chindexer.ObjectRef], len(events))
+	for i := range events {
+		refs[i].Key = key
+		indexes[i] = cloudevent.CloudEvent[chindexer.ObjectRef]{CloudEventHeader: events[i].CloudEventHeader, Data: refs[i]}
+	}
+	if err := s.InsertIndexes(ctx, indexes); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// InsertIndexes stores the given indexes in ClickHouse in a single batch.
+func (s *Service) InsertIndexes(ctx context.Context, indexes []cloudevent.CloudEvent[chindexer.ObjectRef]) error {
+	batch, err := s.chConn.PrepareBatch(ctx, chindexer.InsertStmt)
+	if err != nil {
+		return fmt.Errorf("failed to prepare index batch: %w", err)
+	}
+	for i := range indexes {
+		if err := batch.Append(chindexer.CloudEventToSliceWithRef(&indexes[i].CloudEventHeader, indexes[i].Data)...); err != nil {
+			_ = batch.Abort()
+			return fmt.Errorf("failed to append index to batch: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to store indexes in ClickHouse: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package indexrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStoreCloudEventBatch tests storing many cloud events in one object and reading each back with a ranged get.
+func TestStoreCloudEventBatch(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+	var events []cloudevent.CloudEvent[json.RawMessage]
+	for i := range 5 {
+		events = append(events, cloudevent.CloudEvent[json.RawMessage]{
+			CloudEventHeader: cloudevent.CloudEventHeader{
+				ID:          fmt.Sprintf("event-%d", i),
+				Subject:     subject,
+				Time:        start.Add(time.Duration(i) * time.Minute),
+				DataVersion: dataType,
+			},
+			Data: json.RawMessage(fmt.Sprintf(`{"speed":%d}`, i)),
+		})
+	}
+	key, err := indexService.StoreCloudEventBatch(ctx, "test-bucket", events)
+	require.NoError(t, err)
+	require.Contains(t, key, indexrepo.BatchKeySuffix)
+
+	indexes, err := indexService.ListIndexes(ctx, 10, &indexrepo.SearchOptions{Subject: &subject, TimestampAsc: true})
+	require.NoError(t, err)
+	require.Len(t, indexes, len(events))
+	for _, index := range indexes {
+		require.Equal(t, key, index.Data.Key)
+		require.NotZero(t, index.Data.Length)
+	}
+
+	fetched, err := indexService.ListCloudEventsFromIndexes(ctx, indexes, "test-bucket")
+	require.NoError(t, err)
+	for i, event := range fetched {
+		require.Equal(t, events[i].ID, event.ID)
+		require.JSONEq(t, string(events[i].Data), string(event.Data))
+	}
+
+	latest, err := indexService.GetLatestCloudEvent(ctx, "test-bucket", &indexrepo.SearchOptions{Subject: &subject})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"speed":4}`, string(latest.Data))
+}
+
+func TestGetObjectFromRefRange(t *testing.T) {
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	require.NoError(t, store.Put(ctx, "bucket", "batch", []byte("{\"a\":1}\n{\"b\":2}\n"), nil))
+	require.NoError(t, store.Put(ctx, "bucket", "compressed", []byte("0123456789"), map[string]string{indexrepo.ContentEncodingMetadataKey: "gzip"}))
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), false))
+
+	data, err := indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "batch", Offset: 8, Length: 7}, "bucket")
+	require.NoError(t, err)
+	require.Equal(t, `{"b":2}`, string(data))
+	// the cached range does not shadow other ranges or the whole object
+	data, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "batch", Offset: 0, Length: 7}, "bucket")
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1}`, string(data))
+	data, err = indexService.GetObjectFromKey(ctx, "batch", "bucket")
+	require.NoError(t, err)
+	require.Equal(t, "{\"a\":1}\n{\"b\":2}\n", string(data))
+
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "batch", Offset: 8, Length: 100}, "bucket")
+	require.ErrorIs(t, err, blobstore.ErrInvalidRange)
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "compressed", Offset: 0, Length: 4}, "bucket")
+	require.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package indexrepo
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+)
+
+// CachedObject is an object held by an ObjectCache.
+type CachedObject struct {
+	// Data is the content of the object.
+	Data []byte
+	// ETag is the ETag of the object when it was cached. It is used to revalidate the object.
+	ETag string
+}
+
+// ObjectCache caches object contents by bucket and key.
+// Implementations must be safe for concurrent use. A failing external cache should report a miss.
+type ObjectCache interface {
+	// Get returns the cached object and whether it was found.
+	Get(ctx context.Context, bucket, key string) (CachedObject, bool)
+	// Set stores the object in the cache.
+	Set(ctx context.Context, bucket, key string, obj CachedObject)
+	// Delete removes the object from the cache.
+	Delete(ctx context.Context, bucket, key string)
+}
+
+// CacheStats are the object cache counters of a Service.
+type CacheStats struct {
+	// Hits is the number of reads served from the cache.
+	Hits uint64
+	// Misses is the number of reads that were not in the cache, including stale objects.
+	Misses uint64
+	// Stale is the number of cached objects whose ETag no longer matched the stored object.
+	Stale uint64
+}
+
+// cacheCounters are the atomic counters behind CacheStats.
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	stale  atomic.Uint64
+}
+
+// WithObjectCache sets the cache used for object reads.
+// If revalidate is set a cached object is only used when its ETag matches the stored object,
+// which costs a HEAD request per read but never returns an overwritten object.
+func WithObjectCache(cache ObjectCache, revalidate bool) Option {
+	return func(s *Service) {
+		s.cache = cache
+		s.revalidateCache = revalidate
+	}
+}
+
+// CacheStats returns the object cache counters.
+func (s *Service) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:   s.cacheCounters.hits.Load(),
+		Misses: s.cacheCounters.misses.Load(),
+		Stale:  s.cacheCounters.stale.Load(),
+	}
+}
+
+// invalidateCachedObject removes the object from the cache after it was overwritten or deleted by the service.
+func (s *Service) invalidateCachedObject(ctx context.Context, bucketName, key string) {
+	s.archives.delete(bucketName, key)
+	if s.cache != nil {
+		s.cache.Delete(ctx, bucketName, key)
+	}
+}
+
+// invalidateCachedRefs removes the object and the data the object infos point into it from the cache
+// after the object was deleted by the service.
+func (s *Service) invalidateCachedRefs(ctx context.Context, bucketName, key string, refs []ObjectInfo) {
+	s.archives.delete(bucketName, key)
+	if s.cache == nil {
+		return
+	}
+	s.cache.Delete(ctx, bucketName, key)
+	for _, ref := range refs {
+		if cacheKey, err := objectCacheKey(ref); err == nil && cacheKey != key {
+			s.cache.Delete(ctx, bucketName, cacheKey)
+		}
+	}
+}
+
+// objectCacheKey returns the cache key of the data the object info points to.
+// Ranges of batch objects and rows of archive objects are cached apart from their whole object.
+func objectCacheKey(ref ObjectInfo) (string, error) {
+	switch {
+	case ref.Format == chindexer.DataFormatParquet:
+		return fmt.Sprintf("%s#%d/%d", ref.Key, ref.RowGroup, ref.Offset), nil
+	case ref.Format != "":
+		return "", fmt.Errorf("unknown data format '%s'", ref.Format)
+	case ref.Length > 0:
+		return fmt.Sprintf("%s#%d-%d", ref.Key, ref.Offset, ref.Length), nil
+	default:
+		return ref.Key, nil
+	}
+}
+
+// getCachedObject returns the cached object if it is present and, when revalidation is enabled, still current.
+// The cache key differs from the object key for ranges of batch objects.
+func (s *Service) getCachedObject(ctx context.Context, bucketName, cacheKey, key string) ([]byte, bool) {
+	obj, ok := s.cache.Get(ctx, bucketName, cacheKey)
+	if !ok {
+		s.cacheCounters.misses.Add(1)
+		return nil, false
+	}
+	if s.revalidateCache {
+		info, err := s.store.Head(ctx, bucketName, key)
+		if err != nil || info.ETag != obj.ETag {
+			// a failed head is treated as stale so the following get reports the real error
+			s.cacheCounters.stale.Add(1)
+			s.cacheCounters.misses.Add(1)
+			return nil, false
+		}
+	}
+	s.cacheCounters.hits.Add(1)
+	return obj.Data, true
+}
+
+// LRUCache is an in-memory ObjectCache that evicts the least recently used objects
+// once the total size of the cached objects exceeds its byte limit.
+type LRUCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[lruKey]*list.Element
+}
+
+type lruKey struct {
+	bucket string
+	key    string
+}
+
+type lruEntry struct {
+	key lruKey
+	obj CachedObject
+}
+
+// NewLRUCache creates an LRUCache that holds at most maxBytes of object data.
+// Objects larger than maxBytes are not cached.
+func NewLRUCache(maxBytes int64) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[lruKey]*list.Element{},
+	}
+}
+
+// Get returns a copy of the cached object and marks it as recently used.
+func (c *LRUCache) Get(_ context.Context, bucket, key string) (CachedObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[lruKey{bucket: bucket, key: key}]
+	if !ok {
+		return CachedObject{}, false
+	}
+	c.order.MoveToFront(elem)
+	obj := elem.Value.(*lruEntry).obj
+	obj.Data = slices.Clone(obj.Data)
+	return obj, true
+}
+
+// Set stores a copy of the object and evicts the least recently used objects until the cache fits its limit.
+func (c *LRUCache) Set(_ context.Context, bucket, key string, obj CachedObject) {
+	size := int64(len(obj.Data))
+	if size > c.maxBytes {
+		return
+	}
+	obj.Data = slices.Clone(obj.Data)
+	cacheKey := lruKey{bucket: bucket, key: key}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[cacheKey]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[cacheKey] = c.order.PushFront(&lruEntry{key: cacheKey, obj: obj})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Delete removes the object from the cache.
+func (c *LRUCache) Delete(_ context.Context, bucket, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[lruKey{bucket: bucket, key: key}]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Len returns the number of cached objects.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Size returns the total size of the cached objects in bytes.
+func (c *LRUCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.obj.Data))
+}
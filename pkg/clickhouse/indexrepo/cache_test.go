@@ -0,0 +1,87 @@
+package indexrepo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := indexrepo.NewLRUCache(10)
+
+	cache.Set(ctx, "bucket", "a", indexrepo.CachedObject{Data: []byte("aaaa")})
+	cache.Set(ctx, "bucket", "b", indexrepo.CachedObject{Data: []byte("bbbb")})
+	_, ok := cache.Get(ctx, "other-bucket", "a")
+	require.False(t, ok)
+
+	// reading a makes b the least recently used
+	obj, ok := cache.Get(ctx, "bucket", "a")
+	require.True(t, ok)
+	require.Equal(t, []byte("aaaa"), obj.Data)
+
+	cache.Set(ctx, "bucket", "c", indexrepo.CachedObject{Data: []byte("cccc")})
+	require.Equal(t, 2, cache.Len())
+	require.Equal(t, int64(8), cache.Size())
+	_, ok = cache.Get(ctx, "bucket", "b")
+	require.False(t, ok)
+
+	// objects larger than the cache are not stored
+	cache.Set(ctx, "bucket", "big", indexrepo.CachedObject{Data: make([]byte, 11)})
+	_, ok = cache.Get(ctx, "bucket", "big")
+	require.False(t, ok)
+
+	// replacing an object updates its size
+	cache.Set(ctx, "bucket", "a", indexrepo.CachedObject{Data: []byte("a")})
+	require.Equal(t, int64(5), cache.Size())
+
+	cache.Delete(ctx, "bucket", "a")
+	require.Equal(t, 1, cache.Len())
+	require.Equal(t, int64(4), cache.Size())
+}
+
+func TestServiceObjectCache(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore(t, "bucket", "a")
+
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), false))
+	for range 3 {
+		data, err := indexService.GetObjectFromKey(ctx, "a", "bucket")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"key": "a"}`, string(data))
+	}
+	require.Equal(t, 1, store.gets["a"])
+	require.Equal(t, indexrepo.CacheStats{Hits: 2, Misses: 1}, indexService.CacheStats())
+
+	// without revalidation an object overwritten by another writer is served from the cache
+	require.NoError(t, store.Put(ctx, "bucket", "a", []byte(`{"key": "changed"}`), nil))
+	data, err := indexService.GetObjectFromKey(ctx, "a", "bucket")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"key": "a"}`, string(data))
+}
+
+func TestServiceObjectCacheRevalidation(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore(t, "bucket", "a")
+
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), true))
+	_, err := indexService.GetObjectFromKey(ctx, "a", "bucket")
+	require.NoError(t, err)
+	_, err = indexService.GetObjectFromKey(ctx, "a", "bucket")
+	require.NoError(t, err)
+	require.Equal(t, 1, store.gets["a"])
+
+	require.NoError(t, store.Put(ctx, "bucket", "a", []byte(`{"key": "changed"}`), nil))
+	data, err := indexService.GetObjectFromKey(ctx, "a", "bucket")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"key": "changed"}`, string(data))
+	require.Equal(t, 2, store.gets["a"])
+	require.Equal(t, indexrepo.CacheStats{Hits: 1, Misses: 2, Stale: 1}, indexService.CacheStats())
+
+	require.NoError(t, store.Delete(ctx, "bucket", "a"))
+	_, err = indexService.GetObjectFromKey(ctx, "a", "bucket")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+}
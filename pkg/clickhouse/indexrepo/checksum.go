@@ -0,0 +1,61 @@
+package indexrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+)
+
+// ErrChecksumMismatch is returned when the data read from the blob store does not match its recorded checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMode controls what happens when the data read from the blob store does not match its recorded checksum.
+type ChecksumMode int
+
+const (
+	// ChecksumStrict fails the read with ErrChecksumMismatch.
+	ChecksumStrict ChecksumMode = iota
+	// ChecksumWarn logs the mismatch and returns the data.
+	ChecksumWarn
+)
+
+// ChecksumMetadataKey is the object metadata key that records the checksum of the uncompressed object.
+// It is used to verify whole objects read without an index, eg with GetObjectFromKey.
+const ChecksumMetadataKey = "sha256"
+
+// WithChecksumMode sets how checksum mismatches are handled. It defaults to ChecksumStrict.
+// Data without a recorded checksum, such as data stored before checksums were recorded, is never verified.
+func WithChecksumMode(mode ChecksumMode) Option {
+	return func(s *Service) {
+		s.checksumMode = mode
+	}
+}
+
+// verifyChecksum checks the data against the expected checksum according to the checksum mode.
+// An empty checksum is not verified.
+func (s *Service) verifyChecksum(ctx context.Context, key, checksum string, data []byte) error {
+	if checksum == "" {
+		return nil
+	}
+	actual := chindexer.DataChecksum(data)
+	if actual == checksum {
+		return nil
+	}
+	if s.checksumMode == ChecksumWarn {
+		s.logger.WarnContext(ctx, "object checksum mismatch", "key", key, "expected", checksum, "actual", actual)
+		return nil
+	}
+	return fmt.Errorf("%w: object '%s' has checksum %s, expected %s", ErrChecksumMismatch, key, actual, checksum)
+}
+
+// withChecksumMetadata returns the metadata with the checksum added. The given metadata is not modified.
+func withChecksumMetadata(metadata map[string]string, checksum string) map[string]string {
+	withChecksum := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		withChecksum[k] = v
+	}
+	withChecksum[ChecksumMetadataKey] = checksum
+	return withChecksum
+}
@@ -0,0 +1,88 @@
+package indexrepo_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetObjectChecksum(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(`{"speed":1}`)
+	store := blobstore.NewMemory()
+	require.NoError(t, store.Put(ctx, "bucket", "good", content, map[string]string{indexrepo.ChecksumMetadataKey: chindexer.DataChecksum(content)}))
+	require.NoError(t, store.Put(ctx, "bucket", "corrupt", []byte(`{"speed":2}`), map[string]string{indexrepo.ChecksumMetadataKey: chindexer.DataChecksum(content)}))
+	require.NoError(t, store.Put(ctx, "bucket", "unchecked", content, nil))
+
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), false))
+	data, err := indexService.GetObjectFromKey(ctx, "good", "bucket")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	data, err = indexService.GetObjectFromKey(ctx, "unchecked", "bucket")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	_, err = indexService.GetObjectFromKey(ctx, "corrupt", "bucket")
+	require.ErrorIs(t, err, indexrepo.ErrChecksumMismatch)
+	// corrupt data is not cached
+	_, err = indexService.GetObjectFromKey(ctx, "corrupt", "bucket")
+	require.ErrorIs(t, err, indexrepo.ErrChecksumMismatch)
+
+	// the index checksum is verified for cached data too
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "good", Checksum: chindexer.DataChecksum([]byte("other"))}, "bucket")
+	require.ErrorIs(t, err, indexrepo.ErrChecksumMismatch)
+	_, err = indexService.GetObjectFromRef(ctx, indexrepo.ObjectInfo{Key: "unchecked", Checksum: chindexer.DataChecksum([]byte("other"))}, "bucket")
+	require.ErrorIs(t, err, indexrepo.ErrChecksumMismatch)
+
+	var logs bytes.Buffer
+	warnService := indexrepo.NewWithBlobStore(nil, store,
+		indexrepo.WithChecksumMode(indexrepo.ChecksumWarn),
+		indexrepo.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	data, err = warnService.GetObjectFromKey(ctx, "corrupt", "bucket")
+	require.NoError(t, err)
+	require.Equal(t, `{"speed":2}`, string(data))
+	require.Contains(t, logs.String(), "checksum mismatch")
+	require.Contains(t, logs.String(), "key=corrupt")
+}
+
+// TestStoreObjectChecksum tests that overwritten objects are detected with the checksum in the index.
+func TestStoreObjectChecksum(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+	header := cloudevent.CloudEventHeader{
+		Subject: cloudevent.NFTDID{
+			ChainID:         153,
+			ContractAddress: randAddress(),
+			TokenID:         123456,
+		}.String(),
+		Time:        time.Now(),
+		DataVersion: dataType,
+	}
+	content := []byte(`{"speed":42}`)
+	require.NoError(t, indexService.StoreObject(ctx, "test-bucket", &header, content))
+
+	index, err := indexService.GetLatestIndex(ctx, &indexrepo.SearchOptions{Subject: &header.Subject})
+	require.NoError(t, err)
+	require.Equal(t, chindexer.DataChecksum(content), index.Data.Checksum)
+	_, err = indexService.GetCloudEventFromIndex(ctx, index, "test-bucket")
+	require.NoError(t, err)
+
+	// overwrite the object without going through the service
+	require.NoError(t, store.Put(ctx, "test-bucket", index.Data.Key, []byte(`{"speed":7}`), nil))
+	_, err = indexService.GetCloudEventFromIndex(ctx, index, "test-bucket")
+	require.ErrorIs(t, err, indexrepo.ErrChecksumMismatch)
+}
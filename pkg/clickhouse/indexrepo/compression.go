@@ -0,0 +1,97 @@
+package indexrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the encoding applied to objects before they are stored.
+type Compression string
+
+const (
+	// CompressionNone stores objects as is.
+	CompressionNone Compression = ""
+	// CompressionGzip stores objects compressed with gzip.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd stores objects compressed with zstd.
+	CompressionZstd Compression = "zstd"
+)
+
+// ContentEncodingMetadataKey is the object metadata key that records the compression of a stored object.
+// Objects without it are read as is, which covers objects stored before compression was enabled.
+const ContentEncodingMetadataKey = "content-encoding"
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+// WithCompression sets the compression applied to objects stored by StoreObject.
+// Reads always decompress based on the object metadata regardless of this option.
+func WithCompression(compression Compression) Option {
+	return func(s *Service) {
+		s.compression = compression
+	}
+}
+
+// compress encodes the data and returns the metadata that records the encoding.
+func compress(compression Compression, data []byte) ([]byte, map[string]string, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to gzip object: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, nil, fmt.Errorf("failed to gzip object: %w", err)
+		}
+		return buf.Bytes(), map[string]string{ContentEncodingMetadataKey: string(compression)}, nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return encoder.EncodeAll(data, nil), map[string]string{ContentEncodingMetadataKey: string(compression)}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+// decompress decodes the data according to the encoding recorded in the object metadata.
+func decompress(data []byte, metadata map[string]string) ([]byte, error) {
+	switch encoding := Compression(metadata[ContentEncodingMetadataKey]); encoding {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to gunzip object: %w", err)
+		}
+		defer reader.Close() //nolint // we are not interested in the error here
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to gunzip object: %w", err)
+		}
+		return decoded, nil
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		decoded, err := decoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode zstd object: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unknown object content encoding %q", encoding)
+	}
+}
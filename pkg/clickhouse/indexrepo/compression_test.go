@@ -0,0 +1,86 @@
+package indexrepo_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetObjectFromKeyDecompresses(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(`{"vin": "1HGCM82633A123456"}`)
+
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	_, err := writer.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+
+	store := blobstore.NewMemory()
+	require.NoError(t, store.Put(ctx, "bucket", "legacy", content, nil))
+	require.NoError(t, store.Put(ctx, "bucket", "gzip", gzipped.Bytes(), map[string]string{indexrepo.ContentEncodingMetadataKey: "gzip"}))
+	require.NoError(t, store.Put(ctx, "bucket", "zstd", encoder.EncodeAll(content, nil), map[string]string{indexrepo.ContentEncodingMetadataKey: "zstd"}))
+	require.NoError(t, store.Put(ctx, "bucket", "unknown", content, map[string]string{indexrepo.ContentEncodingMetadataKey: "br"}))
+	require.NoError(t, store.Put(ctx, "bucket", "corrupt", content, map[string]string{indexrepo.ContentEncodingMetadataKey: "gzip"}))
+
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithCompression(indexrepo.CompressionZstd))
+	for _, key := range []string{"legacy", "gzip", "zstd"} {
+		data, err := indexService.GetObjectFromKey(ctx, key, "bucket")
+		require.NoError(t, err, key)
+		require.Equal(t, content, data, key)
+	}
+	for _, key := range []string{"unknown", "corrupt"} {
+		_, err := indexService.GetObjectFromKey(ctx, key, "bucket")
+		require.Error(t, err, key)
+	}
+}
+
+// TestStoreObjectCompression tests that compressed objects are stored with their encoding and read back transparently.
+func TestStoreObjectCompression(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	content := bytes.Repeat([]byte(`{"speed": 42, "vin": "1HGCM82633A123456"}`), 100)
+
+	for _, compression := range []indexrepo.Compression{indexrepo.CompressionNone, indexrepo.CompressionGzip, indexrepo.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			store := blobstore.NewMemory()
+			indexService := indexrepo.NewWithBlobStore(conn, store, indexrepo.WithCompression(compression))
+			header := cloudevent.CloudEventHeader{
+				Subject: cloudevent.NFTDID{
+					ChainID:         153,
+					ContractAddress: randAddress(),
+					TokenID:         123456,
+				}.String(),
+				Time:        time.Now(),
+				DataVersion: dataType,
+			}
+			require.NoError(t, indexService.StoreObject(ctx, "test-bucket", &header, content))
+
+			stored, info, err := store.Get(ctx, "test-bucket", nameindexer.CloudEventToIndexKey(&header))
+			require.NoError(t, err)
+			require.Equal(t, string(compression), info.Metadata[indexrepo.ContentEncodingMetadataKey])
+			if compression != indexrepo.CompressionNone {
+				require.Less(t, len(stored), len(content))
+			}
+
+			event, err := indexService.GetLatestCloudEvent(ctx, "test-bucket", &indexrepo.SearchOptions{Subject: &header.Subject})
+			require.NoError(t, err)
+			require.Equal(t, content, []byte(event.Data))
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package indexrepo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// ErrInvalidCursor is returned when a cursor can not be decoded or does not match the search options.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of a cloud event index in a listing.
+// Listings are ordered by (event_time, id, index_key) so the position is stable even when events share a timestamp.
+type cursor struct {
+	TimeMilli int64  `json:"t"`
+	ID        string `json:"i"`
+	IndexKey  string `json:"k"`
+	Asc       bool   `json:"a"`
+}
+
+// newCursor returns the opaque cursor pointing after the given index.
+func newCursor(index *cloudevent.CloudEvent[ObjectInfo], asc bool) string {
+	c := cursor{
+		TimeMilli: index.Time.UnixMilli(),
+		ID:        index.ID,
+		IndexKey:  index.Data.Key,
+		Asc:       asc,
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes an opaque cursor.
+func decodeCursor(encoded string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return c, nil
+}
+
+// cursorQueryMod returns the query mod that selects the rows after the cursor in the listing order.
+func cursorQueryMod(encoded string, asc bool) (qm.QueryMod, error) {
+	c, err := decodeCursor(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if c.Asc != asc {
+		return nil, fmt.Errorf("%w: cursor sort order does not match the search options", ErrInvalidCursor)
+	}
+	op := " < "
+	if asc {
+		op = " > "
+	}
+	// positional arguments only bind times with second precision so the timestamp is passed as milliseconds.
+	return qm.Where("("+chindexer.TimestampColumn+", "+chindexer.IDColumn+", "+chindexer.IndexKeyColumn+")"+op+
+		"(fromUnixTimestamp64Milli(?, 'UTC'), ?, ?)", c.TimeMilli, c.ID, c.IndexKey), nil
+}
+
+// nextCursor trims indexes fetched with one row more than the page limit to the page and returns the cursor for the next page.
+// The cursor is empty if the extra row was not found because there are no more pages.
+func nextCursor(indexes []cloudevent.CloudEvent[ObjectInfo], limit int, asc bool) ([]cloudevent.CloudEvent[ObjectInfo], string) {
+	if limit <= 0 || len(indexes) <= limit {
+		return indexes, ""
+	}
+	indexes = indexes[:limit]
+	return indexes, newCursor(&indexes[limit-1], asc)
+}
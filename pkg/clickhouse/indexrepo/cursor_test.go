@@ -0,0 +1,69 @@
+package indexrepo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListIndexesPage tests paging through events that share a timestamp.
+func TestListIndexesPage(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	var expectedKeys []string
+	for i := range 5 {
+		eventIdx := &cloudevent.CloudEventHeader{
+			ID:          fmt.Sprintf("event-%d", i),
+			Subject:     subject,
+			Time:        now,
+			Source:      randAddress().Hex(),
+			DataVersion: dataType,
+		}
+		expectedKeys = append(expectedKeys, insertTestData(t, ctx, conn, eventIdx))
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	for _, asc := range []bool{true, false} {
+		// a limit of 5 fills the last page exactly
+		for _, limit := range []int{2, 5} {
+			t.Run(fmt.Sprintf("asc=%t limit=%d", asc, limit), func(t *testing.T) {
+				opts := &indexrepo.SearchOptions{Subject: &subject, TimestampAsc: asc}
+				var keys []string
+				for {
+					events, cursor, err := indexService.ListIndexesPage(ctx, limit, opts)
+					require.NoError(t, err)
+					require.LessOrEqual(t, len(events), limit)
+					for i := range events {
+						keys = append(keys, events[i].Data.Key)
+					}
+					if cursor == "" {
+						break
+					}
+					opts.Cursor = cursor
+				}
+				require.ElementsMatch(t, expectedKeys, keys)
+				require.Len(t, keys, len(expectedKeys))
+			})
+		}
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	_, err := (&indexrepo.SearchOptions{Cursor: "not a cursor"}).QueryMods()
+	require.ErrorIs(t, err, indexrepo.ErrInvalidCursor)
+}
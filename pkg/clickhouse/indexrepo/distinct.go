@@ -0,0 +1,79 @@
+package indexrepo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// DistinctValue is a distinct value of a column together with when it was first and last seen.
+type DistinctValue struct {
+	// Value is the distinct column value.
+	Value string
+	// FirstSeen is the earliest event time with this value.
+	FirstSeen time.Time
+	// LastSeen is the latest event time with this value.
+	LastSeen time.Time
+	// Count is the number of indexes with this value.
+	Count uint64
+}
+
+// ListDistinct returns the distinct values of the column for the indexes that match the given options, ordered by value.
+// The column must be one of subject, event_type, source, producer or data_version.
+// For example the data versions a source has sent, or the producers that reported for a subject.
+func (s *Service) ListDistinct(ctx context.Context, column string, opts *SearchOptions) ([]DistinctValue, error) {
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := buildDistinctQuery(column, opts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.chConn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get distinct %s values: %w", column, err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+
+	var values []DistinctValue
+	for rows.Next() {
+		var value DistinctValue
+		if err := rows.Scan(&value.Value, &value.FirstSeen, &value.LastSeen, &value.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan distinct %s value: %w", column, err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over distinct %s values: %w", column, err)
+	}
+	return values, nil
+}
+
+// buildDistinctQuery builds the query used by ListDistinct.
+func buildDistinctQuery(column string, opts *SearchOptions) (string, []any, error) {
+	if _, ok := groupByColumns[column]; !ok {
+		return "", nil, fmt.Errorf("%w: can not list distinct values of %q", ErrInvalidColumn, column)
+	}
+	mods := []qm.QueryMod{
+		qm.Select(
+			column,
+			"min("+chindexer.TimestampColumn+")",
+			"max("+chindexer.TimestampColumn+")",
+			"count() AS "+countAlias,
+		),
+		qm.From(fromTable(opts)),
+		qm.GroupBy(column),
+		qm.OrderBy(column),
+	}
+	optsMods, err := opts.QueryMods()
+	if err != nil {
+		return "", nil, err
+	}
+	mods = append(mods, optsMods...)
+	query, args := newQuery(mods...)
+	return query, args, nil
+}
@@ -0,0 +1,48 @@
+package indexrepo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListDistinct tests listing the distinct values of a column.
+func TestListDistinct(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := randAddress().Hex()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	events := []cloudevent.CloudEventHeader{
+		{Subject: subject, Source: source, Time: start, DataVersion: "v1"},
+		{Subject: subject, Source: source, Time: start.Add(time.Hour), DataVersion: "v1"},
+		{Subject: subject, Source: source, Time: start.Add(2 * time.Hour), DataVersion: "v2"},
+	}
+	for i := range events {
+		_ = insertTestData(t, ctx, conn, &events[i])
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	values, err := indexService.ListDistinct(ctx, chindexer.DataVersionColumn, &indexrepo.SearchOptions{Source: &source})
+	require.NoError(t, err)
+	require.Equal(t, []indexrepo.DistinctValue{
+		{Value: "v1", FirstSeen: start, LastSeen: start.Add(time.Hour), Count: 2},
+		{Value: "v2", FirstSeen: start.Add(2 * time.Hour), LastSeen: start.Add(2 * time.Hour), Count: 1},
+	}, values)
+
+	_, err = indexService.ListDistinct(ctx, chindexer.IndexKeyColumn, nil)
+	require.ErrorIs(t, err, indexrepo.ErrInvalidColumn)
+}
@@ -0,0 +1,40 @@
+package indexrepo
+
+import (
+	"strconv"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+)
+
+// BuildListIndexesQuery exposes buildListIndexesQuery for tests.
+var BuildListIndexesQuery = buildListIndexesQuery
+
+// LatestTableCompatible exposes latestTableCompatible for tests.
+var LatestTableCompatible = latestTableCompatible
+
+// BuildSampledIndexesQuery exposes buildSampledIndexesQuery for tests.
+var BuildSampledIndexesQuery = buildSampledIndexesQuery
+
+// WriteArchive writes an archive with the given payloads for tests.
+func WriteArchive(payloads [][]byte, rowGroupSize int) ([]byte, []chindexer.ObjectRef, error) {
+	rows := make([]archiveRow, len(payloads))
+	for i, payload := range payloads {
+		rows[i] = archiveRow{ID: strconv.Itoa(i), Data: payload}
+	}
+	return writeArchive(rows, rowGroupSize)
+}
+
+// WriteEventArchive writes an archive of the cloud events with the given headers for tests.
+func WriteEventArchive(hdrs []cloudevent.CloudEventHeader) ([]byte, error) {
+	rows := make([]archiveRow, len(hdrs))
+	for i := range hdrs {
+		var err error
+		rows[i], err = newArchiveRow(&hdrs[i], []byte(`{"speed":0}`))
+		if err != nil {
+			return nil, err
+		}
+	}
+	archive, _, err := writeArchive(rows, archiveRowGroupSize)
+	return archive, err
+}
@@ -0,0 +1,157 @@
+package indexrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultFetchConcurrency is the default maximum number of objects fetched at once.
+const defaultFetchConcurrency = 10
+
+// WithFetchConcurrency sets the maximum number of objects fetched at once. Values below one fetch objects one at a time.
+func WithFetchConcurrency(n int) Option {
+	return func(s *Service) {
+		s.fetchConcurrency = max(n, 1)
+	}
+}
+
+// PartialFetchError is returned by the partial fetch methods when some objects could not be fetched.
+type PartialFetchError struct {
+	// Errors holds the error of each failed item keyed by its position in the input.
+	Errors map[int]error
+}
+
+// Error returns a summary of the failed items.
+func (e *PartialFetchError) Error() string {
+	positions := make([]int, 0, len(e.Errors))
+	for pos := range e.Errors {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+	msgs := make([]string, len(positions))
+	for i, pos := range positions {
+		msgs[i] = fmt.Sprintf("item %d: %v", pos, e.Errors[pos])
+	}
+	return fmt.Sprintf("failed to fetch %d objects: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed items.
+func (e *PartialFetchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ListCloudEventsFromIndexesPartial is like ListCloudEventsFromIndexes but does not stop on the first failed fetch.
+// Events that could not be fetched are left empty and their errors are returned in a *PartialFetchError.
+func (s *Service) ListCloudEventsFromIndexesPartial(ctx context.Context, indexes []cloudevent.CloudEvent[ObjectInfo], bucketName string) ([]cloudevent.CloudEvent[json.RawMessage], error) {
+	refs := make([]ObjectInfo, len(indexes))
+	for i := range indexes {
+		refs[i] = indexes[i].Data
+	}
+	objects, refErrs, err := s.fetchObjects(ctx, bucketName, refs, true)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]cloudevent.CloudEvent[json.RawMessage], len(indexes))
+	partialErr := &PartialFetchError{Errors: map[int]error{}}
+	for i := range indexes {
+		if err, ok := refErrs[refs[i]]; ok {
+			partialErr.Errors[i] = err
+			continue
+		}
+		events[i] = toCloudEvent(&indexes[i].CloudEventHeader, objects[refs[i]])
+	}
+	if len(partialErr.Errors) > 0 {
+		return events, partialErr
+	}
+	return events, nil
+}
+
+// ListObjectsFromKeysPartial is like ListObjectsFromKeys but does not stop on the first failed fetch.
+// Objects that could not be fetched are left nil and their errors are returned in a *PartialFetchError.
+func (s *Service) ListObjectsFromKeysPartial(ctx context.Context, keys []string, bucketName string) ([][]byte, error) {
+	objects, refErrs, err := s.fetchObjects(ctx, bucketName, keysToRefs(keys), true)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(keys))
+	partialErr := &PartialFetchError{Errors: map[int]error{}}
+	for i, key := range keys {
+		if err, ok := refErrs[ObjectInfo{Key: key}]; ok {
+			partialErr.Errors[i] = err
+			continue
+		}
+		data[i] = objects[ObjectInfo{Key: key}]
+	}
+	if len(partialErr.Errors) > 0 {
+		return data, partialErr
+	}
+	return data, nil
+}
+
+// keysToRefs returns object infos for the whole objects with the given keys.
+func keysToRefs(keys []string) []ObjectInfo {
+	refs := make([]ObjectInfo, len(keys))
+	for i, key := range keys {
+		refs[i] = ObjectInfo{Key: key}
+	}
+	return refs
+}
+
+// fetchObjects fetches each distinct object or range once with bounded concurrency and returns the data by object info.
+// Unless partial is set the first failed fetch cancels the remaining fetches and its error is returned.
+// With partial set the fetches continue and the errors are returned by object info.
+func (s *Service) fetchObjects(ctx context.Context, bucketName string, refs []ObjectInfo, partial bool) (map[ObjectInfo][]byte, map[ObjectInfo]error, error) {
+	unique := make([]ObjectInfo, 0, len(refs))
+	seen := make(map[ObjectInfo]struct{}, len(refs))
+	for _, ref := range refs {
+		if _, ok := seen[ref]; !ok {
+			seen[ref] = struct{}{}
+			unique = append(unique, ref)
+		}
+	}
+
+	objects := make(map[ObjectInfo][]byte, len(unique))
+	refErrs := map[ObjectInfo]error{}
+	var mu sync.Mutex
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.fetchConcurrency)
+	for _, ref := range unique {
+		if !partial && groupCtx.Err() != nil {
+			break
+		}
+		group.Go(func() error {
+			data, err := s.GetObjectFromRef(groupCtx, ref, bucketName)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !partial {
+					return fmt.Errorf("failed to get data from key '%s': %w", ref.Key, err)
+				}
+				refErrs[ref] = err
+				return nil
+			}
+			objects[ref] = data
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, nil, err
+	}
+	if !partial {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return objects, refErrs, nil
+}
@@ -0,0 +1,135 @@
+package indexrepo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+var errFetch = errors.New("fetch failed")
+
+// countingStore wraps a blob store to count gets, track how many run at once and fail selected keys.
+type countingStore struct {
+	blobstore.BlobStore
+	mu       sync.Mutex
+	gets     map[string]int
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+	failKeys map[string]bool
+}
+
+func newCountingStore(t *testing.T, bucket string, keys ...string) *countingStore {
+	t.Helper()
+	store := &countingStore{BlobStore: blobstore.NewMemory(), gets: map[string]int{}, failKeys: map[string]bool{}}
+	for _, key := range keys {
+		require.NoError(t, store.Put(context.Background(), bucket, key, []byte(`{"key": "`+key+`"}`), nil))
+	}
+	return store
+}
+
+func (c *countingStore) Get(ctx context.Context, bucket, key string) ([]byte, blobstore.BlobInfo, error) {
+	running := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		seen := c.maxSeen.Load()
+		if running <= seen || c.maxSeen.CompareAndSwap(seen, running) {
+			break
+		}
+	}
+	c.mu.Lock()
+	c.gets[key]++
+	c.mu.Unlock()
+	// give other fetches time to start so the concurrency limit is exercised
+	time.Sleep(5 * time.Millisecond)
+	if err := ctx.Err(); err != nil {
+		return nil, blobstore.BlobInfo{}, err
+	}
+	if c.failKeys[key] {
+		return nil, blobstore.BlobInfo{}, errFetch
+	}
+	return c.BlobStore.Get(ctx, bucket, key)
+}
+
+func TestListCloudEventsFromIndexesConcurrent(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	var indexes []cloudevent.CloudEvent[indexrepo.ObjectInfo]
+	for i := range 20 {
+		key := fmt.Sprintf("key-%02d", i)
+		keys = append(keys, key)
+		indexes = append(indexes, cloudevent.CloudEvent[indexrepo.ObjectInfo]{
+			CloudEventHeader: cloudevent.CloudEventHeader{ID: key},
+			Data:             indexrepo.ObjectInfo{Key: key},
+		})
+	}
+	// the same object backing two cloud events is only fetched once
+	indexes = append(indexes, cloudevent.CloudEvent[indexrepo.ObjectInfo]{
+		CloudEventHeader: cloudevent.CloudEventHeader{ID: "duplicate"},
+		Data:             indexrepo.ObjectInfo{Key: keys[0]},
+	})
+	store := newCountingStore(t, "bucket", keys...)
+	indexService := indexrepo.NewWithBlobStore(nil, store, indexrepo.WithFetchConcurrency(4))
+
+	events, err := indexService.ListCloudEventsFromIndexes(ctx, indexes, "bucket")
+	require.NoError(t, err)
+	require.Len(t, events, len(indexes))
+	for i, event := range events {
+		require.Equal(t, indexes[i].ID, event.ID)
+		require.JSONEq(t, `{"key": "`+indexes[i].Data.Key+`"}`, string(event.Data))
+	}
+	for _, key := range keys {
+		require.Equal(t, 1, store.gets[key], key)
+	}
+	require.LessOrEqual(t, store.maxSeen.Load(), int32(4))
+	require.Greater(t, store.maxSeen.Load(), int32(1))
+
+	objects, err := indexService.ListObjectsFromKeys(ctx, []string{keys[3], keys[1], keys[3]}, "bucket")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"key": "key-03"}`, string(objects[0]))
+	require.JSONEq(t, `{"key": "key-01"}`, string(objects[1]))
+	require.JSONEq(t, `{"key": "key-03"}`, string(objects[2]))
+}
+
+func TestListCloudEventsFromIndexesErrors(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"a", "b", "c"}
+	store := newCountingStore(t, "bucket", keys...)
+	store.failKeys["b"] = true
+	indexService := indexrepo.NewWithBlobStore(nil, store)
+
+	_, err := indexService.ListObjectsFromKeys(ctx, keys, "bucket")
+	require.ErrorIs(t, err, errFetch)
+
+	objects, err := indexService.ListObjectsFromKeysPartial(ctx, keys, "bucket")
+	var partialErr *indexrepo.PartialFetchError
+	require.ErrorAs(t, err, &partialErr)
+	require.ErrorIs(t, err, errFetch)
+	require.Len(t, partialErr.Errors, 1)
+	require.ErrorIs(t, partialErr.Errors[1], errFetch)
+	require.NotNil(t, objects[0])
+	require.Nil(t, objects[1])
+	require.NotNil(t, objects[2])
+
+	indexes := []cloudevent.CloudEvent[indexrepo.ObjectInfo]{
+		{CloudEventHeader: cloudevent.CloudEventHeader{ID: "1"}, Data: indexrepo.ObjectInfo{Key: "a"}},
+		{CloudEventHeader: cloudevent.CloudEventHeader{ID: "2"}, Data: indexrepo.ObjectInfo{Key: "b"}},
+	}
+	events, err := indexService.ListCloudEventsFromIndexesPartial(ctx, indexes, "bucket")
+	require.ErrorAs(t, err, &partialErr)
+	require.Equal(t, "1", events[0].ID)
+	require.Empty(t, events[1].ID)
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = indexService.ListObjectsFromKeys(canceledCtx, keys, "bucket")
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,261 @@
+package indexrepo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// ErrInvalidFilter is returned when a filter references an unknown column or has invalid values.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// filterColumns are the columns that can be referenced by a filter.
+var filterColumns = map[string]struct{}{
+	chindexer.SubjectColumn:         {},
+	chindexer.TimestampColumn:       {},
+	chindexer.TypeColumn:            {},
+	chindexer.IDColumn:              {},
+	chindexer.SourceColumn:          {},
+	chindexer.ProducerColumn:        {},
+	chindexer.DataContentTypeColumn: {},
+	chindexer.DataVersionColumn:     {},
+	chindexer.ExtrasColumn:          {},
+	chindexer.IndexKeyColumn:        {},
+}
+
+// Filter is a boolean expression over the cloud event index columns.
+// Filters are built with And, Or, Not, Eq, In, Range, Prefix, ExtrasHasKey and ExtrasEq
+// and are compiled to parameterized ClickHouse SQL.
+type Filter interface {
+	// toSQL returns the SQL condition for the filter and its arguments.
+	toSQL() (string, []any, error)
+}
+
+// AndFilter matches when all of its filters match. An empty AndFilter matches everything.
+type AndFilter []Filter
+
+// OrFilter matches when any of its filters match. An empty OrFilter matches nothing.
+type OrFilter []Filter
+
+// NotFilter matches when its filter does not match.
+type NotFilter struct {
+	Filter Filter
+}
+
+// EqFilter matches when the column equals the value.
+type EqFilter struct {
+	Column string
+	Value  any
+}
+
+// InFilter matches when the column equals one of the values. An empty InFilter matches nothing.
+type InFilter struct {
+	Column string
+	Values []any
+}
+
+// RangeFilter matches when the column is between From and To.
+// A nil bound is unbounded. Bounds are exclusive unless the matching inclusive flag is set.
+type RangeFilter struct {
+	Column        string
+	From          any
+	To            any
+	FromInclusive bool
+	ToInclusive   bool
+}
+
+// PrefixFilter matches when the column starts with the prefix.
+type PrefixFilter struct {
+	Column string
+	Prefix string
+}
+
+// ExtrasHasKeyFilter matches when the extras contain the key.
+type ExtrasHasKeyFilter struct {
+	Key string
+}
+
+// ExtrasEqFilter matches when the extras contain the key with the value.
+// Non-string extras values are matched against their JSON encoding.
+type ExtrasEqFilter struct {
+	Key   string
+	Value string
+}
+
+// And returns a filter that matches when all of the filters match. Nil filters are ignored.
+func And(filters ...Filter) AndFilter { return AndFilter(filters) }
+
+// Or returns a filter that matches when any of the filters match.
+// Nil filters are ignored, so an Or with only nil filters matches nothing.
+func Or(filters ...Filter) OrFilter { return OrFilter(filters) }
+
+// Not returns a filter that matches when the filter does not match.
+func Not(filter Filter) NotFilter { return NotFilter{Filter: filter} }
+
+// Eq returns a filter that matches when the column equals the value.
+func Eq(column string, value any) EqFilter { return EqFilter{Column: column, Value: value} }
+
+// In returns a filter that matches when the column equals one of the values.
+func In[T any](column string, values ...T) InFilter {
+	anyValues := make([]any, len(values))
+	for i, v := range values {
+		anyValues[i] = v
+	}
+	return InFilter{Column: column, Values: anyValues}
+}
+
+// Range returns a filter that matches when from <= column < to. A nil bound is unbounded.
+func Range(column string, from, to any) RangeFilter {
+	return RangeFilter{Column: column, From: from, To: to, FromInclusive: true}
+}
+
+// Prefix returns a filter that matches when the column starts with the prefix.
+func Prefix(column, prefix string) PrefixFilter { return PrefixFilter{Column: column, Prefix: prefix} }
+
+// ExtrasHasKey returns a filter that matches when the extras contain the key.
+func ExtrasHasKey(key string) ExtrasHasKeyFilter { return ExtrasHasKeyFilter{Key: key} }
+
+// ExtrasEq returns a filter that matches when the extras contain the key with the value.
+func ExtrasEq(key, value string) ExtrasEqFilter { return ExtrasEqFilter{Key: key, Value: value} }
+
+func (f AndFilter) toSQL() (string, []any, error) {
+	return joinFilters(f, " AND ", "1")
+}
+
+func (f OrFilter) toSQL() (string, []any, error) {
+	return joinFilters(f, " OR ", "0")
+}
+
+func (f NotFilter) toSQL() (string, []any, error) {
+	if f.Filter == nil {
+		return "", nil, fmt.Errorf("%w: not filter is empty", ErrInvalidFilter)
+	}
+	clause, args, err := f.Filter.toSQL()
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT (" + clause + ")", args, nil
+}
+
+func (f EqFilter) toSQL() (string, []any, error) {
+	if err := validateColumn(f.Column); err != nil {
+		return "", nil, err
+	}
+	placeholder, arg := bindValue(f.Value)
+	return f.Column + " = " + placeholder, []any{arg}, nil
+}
+
+func (f InFilter) toSQL() (string, []any, error) {
+	if err := validateColumn(f.Column); err != nil {
+		return "", nil, err
+	}
+	if len(f.Values) == 0 {
+		return "0", nil, nil
+	}
+	placeholders := make([]string, len(f.Values))
+	args := make([]any, len(f.Values))
+	for i, v := range f.Values {
+		placeholders[i], args[i] = bindValue(v)
+	}
+	return f.Column + " IN (" + strings.Join(placeholders, ", ") + ")", args, nil
+}
+
+func (f RangeFilter) toSQL() (string, []any, error) {
+	if err := validateColumn(f.Column); err != nil {
+		return "", nil, err
+	}
+	var clauses []string
+	var args []any
+	if f.From != nil {
+		op := " > "
+		if f.FromInclusive {
+			op = " >= "
+		}
+		placeholder, arg := bindValue(f.From)
+		clauses = append(clauses, f.Column+op+placeholder)
+		args = append(args, arg)
+	}
+	if f.To != nil {
+		op := " < "
+		if f.ToInclusive {
+			op = " <= "
+		}
+		placeholder, arg := bindValue(f.To)
+		clauses = append(clauses, f.Column+op+placeholder)
+		args = append(args, arg)
+	}
+	if len(clauses) == 0 {
+		return "1", nil, nil
+	}
+	return strings.Join(clauses, " AND "), args, nil
+}
+
+func (f PrefixFilter) toSQL() (string, []any, error) {
+	if err := validateColumn(f.Column); err != nil {
+		return "", nil, err
+	}
+	if f.Column == chindexer.TimestampColumn {
+		return "", nil, fmt.Errorf("%w: prefix filter is not supported on column %s", ErrInvalidFilter, f.Column)
+	}
+	return "startsWith(" + f.Column + ", ?)", []any{f.Prefix}, nil
+}
+
+func (f ExtrasHasKeyFilter) toSQL() (string, []any, error) {
+	return "mapContains(" + chindexer.ExtrasMapColumn + ", ?)", []any{f.Key}, nil
+}
+
+func (f ExtrasEqFilter) toSQL() (string, []any, error) {
+	return "mapContains(" + chindexer.ExtrasMapColumn + ", ?) AND " + chindexer.ExtrasMapColumn + "[?] = ?", []any{f.Key, f.Key, f.Value}, nil
+}
+
+// joinFilters joins the SQL of the filters with the given operator wrapping each in parentheses.
+// Nil filters are skipped and empty is returned when no filters are left.
+func joinFilters(filters []Filter, op, empty string) (string, []any, error) {
+	clauses := make([]string, 0, len(filters))
+	var args []any
+	for _, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		clause, filterArgs, err := filter.toSQL()
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, "("+clause+")")
+		args = append(args, filterArgs...)
+	}
+	if len(clauses) == 0 {
+		return empty, nil, nil
+	}
+	return strings.Join(clauses, op), args, nil
+}
+
+// validateColumn returns an error if the column can not be used in a filter.
+func validateColumn(column string) error {
+	if _, ok := filterColumns[column]; !ok {
+		return fmt.Errorf("%w: unknown column %q", ErrInvalidFilter, column)
+	}
+	return nil
+}
+
+// bindValue returns the placeholder and argument for a filter value.
+// Positional arguments only bind times with second precision so times are passed as milliseconds.
+func bindValue(value any) (string, any) {
+	if t, ok := value.(time.Time); ok {
+		return "fromUnixTimestamp64Milli(?, 'UTC')", t.UnixMilli()
+	}
+	return "?", value
+}
+
+// filterQueryMod compiles the filter to a where query mod.
+func filterQueryMod(filter Filter) (qm.QueryMod, error) {
+	clause, args, err := filter.toSQL()
+	if err != nil {
+		return nil, err
+	}
+	return qm.Where(clause, args...), nil
+}
@@ -0,0 +1,111 @@
+package indexrepo_test
+
+import (
+	"testing"
+	"time"
+
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterSQL(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	const selectPrefix = "SELECT `subject`, `event_time`, `event_type`, `id`, `source`, `producer`, `data_content_type`, `data_version`, `extras`, `data_offset`, `data_length`, `data_format`, `data_row_group`, `data_checksum`, `index_key` FROM `cloud_event` WHERE "
+	const orderSuffix = " ORDER BY event_time DESC, id DESC, index_key DESC LIMIT 10;"
+
+	tests := []struct {
+		name          string
+		opts          *indexrepo.SearchOptions
+		expectedWhere string
+		expectedArgs  []any
+		expectedError bool
+	}{
+		{
+			name: "or of ands",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.Or(
+					indexrepo.And(
+						indexrepo.Eq(chindexer.TypeColumn, "dimo.status"),
+						indexrepo.Eq(chindexer.SourceColumn, "0x1"),
+					),
+					indexrepo.Eq(chindexer.TypeColumn, "dimo.fingerprint"),
+				),
+			},
+			expectedWhere: "((((event_type = ?) AND (source = ?)) OR (event_type = ?)))",
+			expectedArgs:  []any{"dimo.status", "0x1", "dimo.fingerprint"},
+		},
+		{
+			name: "not in and prefix",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.And(
+					indexrepo.Not(indexrepo.In(chindexer.SubjectColumn, "a", "b")),
+					indexrepo.Prefix(chindexer.ProducerColumn, "did:"),
+				),
+			},
+			expectedWhere: "(((NOT (subject IN (?, ?))) AND (startsWith(producer, ?))))",
+			expectedArgs:  []any{"a", "b", "did:"},
+		},
+		{
+			name: "time range",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.Range(chindexer.TimestampColumn, ts, ts.Add(time.Hour)),
+			},
+			expectedWhere: "((event_time >= fromUnixTimestamp64Milli(?, 'UTC') AND event_time < fromUnixTimestamp64Milli(?, 'UTC')))",
+			expectedArgs:  []any{ts.UnixMilli(), ts.Add(time.Hour).UnixMilli()},
+		},
+		{
+			name: "inclusive bounds",
+			opts: &indexrepo.SearchOptions{
+				After:           ts,
+				AfterInclusive:  true,
+				Before:          ts.Add(time.Hour),
+				BeforeInclusive: true,
+			},
+			expectedWhere: "((event_time >= fromUnixTimestamp64Milli(?, 'UTC')) AND (event_time <= fromUnixTimestamp64Milli(?, 'UTC')))",
+			expectedArgs:  []any{ts.UnixMilli(), ts.Add(time.Hour).UnixMilli()},
+		},
+		{
+			name: "options are combined with the filter",
+			opts: &indexrepo.SearchOptions{
+				Subject: ref("a"),
+				Filter:  indexrepo.ExtrasEq("vin", "123"),
+			},
+			expectedWhere: "((subject = ?) AND (mapContains(extras_map, ?) AND extras_map[?] = ?))",
+			expectedArgs:  []any{"a", "vin", "vin", "123"},
+		},
+		{
+			name: "or of nil filters matches nothing",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.Or(nil, nil),
+			},
+			expectedWhere: "((0))",
+		},
+		{
+			name: "and of nil filters matches everything",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.And(nil, nil),
+			},
+			expectedWhere: "((1))",
+		},
+		{
+			name: "unknown column",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.Eq("subject; DROP TABLE cloud_event", "a"),
+			},
+			expectedError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := indexrepo.BuildListIndexesQuery(chindexer.TableName, 10, tt.opts)
+			if tt.expectedError {
+				require.ErrorIs(t, err, indexrepo.ErrInvalidFilter)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, selectPrefix+tt.expectedWhere+orderSuffix, query)
+			require.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
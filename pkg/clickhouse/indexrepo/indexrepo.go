@@ -2,33 +2,80 @@
 package indexrepo
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"iter"
+	"log/slog"
+	"reflect"
+	"slices"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
 	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
 	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/volatiletech/sqlboiler/v4/drivers"
 	"github.com/volatiletech/sqlboiler/v4/queries"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 )
 
-// Service manages and retrieves data messages from indexed objects in S3.
+// ErrInvalidTimeRange is returned when the time bounds of the search options can not match any cloud event.
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+// Service manages and retrieves data messages from indexed objects in a blob store.
 type Service struct {
-	objGetter ObjectGetter
-	chConn    clickhouse.Conn
+	store            blobstore.BlobStore
+	chConn           clickhouse.Conn
+	now              func() time.Time
+	fetchConcurrency int
+	cache            ObjectCache
+	revalidateCache  bool
+	cacheCounters    cacheCounters
+	compression      Compression
+	checksumMode     ChecksumMode
+	logger           *slog.Logger
+	outbox           Outbox
+	archives         *archiveFileCache
+	objGetter        ObjectGetter
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger sets the logger used for problems that do not fail a call. It defaults to slog.Default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used to resolve relative time windows. It defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
 }
 
+// ObjectInfo locates the data of a cloud event in its backing object.
 type ObjectInfo struct {
+	// Key is the key of the backing object.
 	Key string
+	// Offset is the byte offset of the cloud event in a batch object.
+	Offset uint64
+	// Length is the byte length of the cloud event in a batch object. Zero means the whole object.
+	Length uint64
+	// Format is the format of the backing object, empty for raw data or chindexer.DataFormatParquet for archives.
+	// For archives Offset is the row of the cloud event in its row group.
+	Format string
+	// RowGroup is the row group of the cloud event in an archive object.
+	RowGroup uint32
+	// Checksum is the hex encoded SHA-256 of the data the object info points to. Empty if it was not recorded.
+	Checksum string
 }
 
 // ObjectGetter is an interface for getting an object from S3.
@@ -37,18 +84,82 @@ type ObjectGetter interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 }
 
-// New creates a new instance of serviceService.
-func New(chConn clickhouse.Conn, objGetter ObjectGetter) *Service {
-	return &Service{
-		objGetter: objGetter,
-		chConn:    chConn,
+// ObjectDeleter is an interface for deleting an object from S3.
+// DeleteExpiredObjects requires the ObjectGetter passed to New to implement it and HeadObject.
+type ObjectDeleter interface {
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// New creates a new instance of serviceService that stores objects in S3.
+// Features that head, list or delete objects require the getter to implement the matching S3 client method,
+// use NewWithS3Client to have that checked at compile time.
+// DeleteExpiredObjects requires both DeleteObject and HeadObject and fails before querying ClickHouse without them.
+func New(chConn clickhouse.Conn, objGetter ObjectGetter, opts ...Option) *Service {
+	var store blobstore.BlobStore
+	if objGetter != nil {
+		store = blobstore.NewS3(objectGetterClient{objGetter})
+	}
+	s := NewWithBlobStore(chConn, store, opts...)
+	s.objGetter = objGetter
+	return s
+}
+
+// NewWithS3Client creates a new instance of serviceService that stores objects in S3 using a client with the full set of methods used by the blob store.
+func NewWithS3Client(chConn clickhouse.Conn, client blobstore.S3Client, opts ...Option) *Service {
+	var store blobstore.BlobStore
+	if client != nil {
+		store = blobstore.NewS3(client)
+	}
+	return NewWithBlobStore(chConn, store, opts...)
+}
+
+// NewWithBlobStore creates a new instance of serviceService that stores objects in the given blob store.
+func NewWithBlobStore(chConn clickhouse.Conn, store blobstore.BlobStore, opts ...Option) *Service {
+	s := &Service{
+		store:            store,
+		chConn:           chConn,
+		now:              time.Now,
+		fetchConcurrency: defaultFetchConcurrency,
+		logger:           slog.Default(),
+		archives:         newArchiveFileCache(archiveFileCacheSize),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// resolveOptions returns the options with the relative time window resolved against the service clock.
+// The given options are not modified.
+func (s *Service) resolveOptions(opts *SearchOptions) (*SearchOptions, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	resolved := *opts
+	if resolved.Window > 0 && resolved.After.IsZero() {
+		resolved.After = s.now().Add(-resolved.Window)
+		resolved.AfterInclusive = true
+		resolved.Window = 0
+	}
+	if err := resolved.Validate(); err != nil {
+		return nil, err
 	}
+	return &resolved, nil
 }
 
 // GetLatestIndex returns the latest cloud event index that matches the given options.
+// The latest table is read instead of the full table whenever the options allow it.
 func (s *Service) GetLatestIndex(ctx context.Context, opts *SearchOptions) (cloudevent.CloudEvent[ObjectInfo], error) {
 	opts.TimestampAsc = false
-	events, err := s.ListIndexes(ctx, 1, opts)
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return cloudevent.CloudEvent[ObjectInfo]{}, err
+	}
+	table := fromTable(opts)
+	if latestTableCompatible(opts) {
+		table = chindexer.LatestTableName
+	}
+	events, err := s.listIndexes(ctx, table, 1, opts)
 	if err != nil {
 		return cloudevent.CloudEvent[ObjectInfo]{}, err
 	}
@@ -56,64 +167,229 @@ func (s *Service) GetLatestIndex(ctx context.Context, opts *SearchOptions) (clou
 }
 
 // ListIndexes fetches and returns a list of index for cloud events that match the given options.
+// A limit of zero or less matches no cloud events.
 func (s *Service) ListIndexes(ctx context.Context, limit int, opts *SearchOptions) ([]cloudevent.CloudEvent[ObjectInfo], error) {
+	return s.listIndexes(ctx, fromTable(opts), limit, opts)
+}
+
+// listIndexes fetches and returns a list of index from the given table.
+func (s *Service) listIndexes(ctx context.Context, table string, limit int, opts *SearchOptions) ([]cloudevent.CloudEvent[ObjectInfo], error) {
+	// iterIndexes treats a limit of zero or less as no limit, which must not load the whole table here.
+	if limit <= 0 {
+		return nil, fmt.Errorf("no cloud events found %w", sql.ErrNoRows)
+	}
+	var cloudEvents []cloudevent.CloudEvent[ObjectInfo]
+	for event, err := range s.iterIndexes(ctx, table, limit, opts) {
+		if err != nil {
+			return nil, err
+		}
+		cloudEvents = append(cloudEvents, event)
+	}
+	if len(cloudEvents) == 0 {
+		return nil, fmt.Errorf("no cloud events found %w", sql.ErrNoRows)
+	}
+	return cloudEvents, nil
+}
+
+// IterIndexes returns an iterator over the indexes for cloud events that match the given options.
+// Rows are streamed from ClickHouse instead of being collected first, and the query is closed when iteration stops.
+// A limit of zero or less iterates over all matching indexes. Unlike ListIndexes no error is returned when nothing matches.
+func (s *Service) IterIndexes(ctx context.Context, limit int, opts *SearchOptions) iter.Seq2[cloudevent.CloudEvent[ObjectInfo], error] {
+	return s.iterIndexes(ctx, fromTable(opts), limit, opts)
+}
+
+// iterIndexes returns an iterator over the indexes from the given table.
+func (s *Service) iterIndexes(ctx context.Context, table string, limit int, opts *SearchOptions) iter.Seq2[cloudevent.CloudEvent[ObjectInfo], error] {
+	return func(yield func(cloudevent.CloudEvent[ObjectInfo], error) bool) {
+		opts, err := s.resolveOptions(opts)
+		if err != nil {
+			yield(cloudevent.CloudEvent[ObjectInfo]{}, err)
+			return
+		}
+		query, args, err := buildListIndexesQuery(table, limit, opts)
+		if err != nil {
+			yield(cloudevent.CloudEvent[ObjectInfo]{}, err)
+			return
+		}
+		rows, err := s.chConn.Query(ctx, query, args...)
+		if err != nil {
+			yield(cloudevent.CloudEvent[ObjectInfo]{}, fmt.Errorf("failed to get cloud events: %w", err))
+			return
+		}
+		defer rows.Close() //nolint // we are not interested in the error here
+		for rows.Next() {
+			event, err := scanIndex(rows)
+			if err != nil {
+				yield(cloudevent.CloudEvent[ObjectInfo]{}, err)
+				return
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(cloudevent.CloudEvent[ObjectInfo]{}, fmt.Errorf("failed to iterate over cloud events: %w", err))
+		}
+	}
+}
+
+// indexColumns are the columns selected for a cloud event index in the order they are scanned by scanIndex.
+var indexColumns = []string{
+	chindexer.SubjectColumn,
+	chindexer.TimestampColumn,
+	chindexer.TypeColumn,
+	chindexer.IDColumn,
+	chindexer.SourceColumn,
+	chindexer.ProducerColumn,
+	chindexer.DataContentTypeColumn,
+	chindexer.DataVersionColumn,
+	chindexer.ExtrasColumn,
+	chindexer.DataOffsetColumn,
+	chindexer.DataLengthColumn,
+	chindexer.DataFormatColumn,
+	chindexer.DataRowGroupColumn,
+	chindexer.DataChecksumColumn,
+	chindexer.IndexKeyColumn,
+}
+
+// scanIndex scans a row selected with indexColumns into a cloud event index.
+func scanIndex(row interface{ Scan(dest ...any) error }) (cloudevent.CloudEvent[ObjectInfo], error) {
+	var event cloudevent.CloudEvent[ObjectInfo]
+	var extras string
+	err := row.Scan(&event.Subject, &event.Time, &event.Type, &event.ID, &event.Source, &event.Producer, &event.DataContentType, &event.DataVersion, &extras,
+		&event.Data.Offset, &event.Data.Length, &event.Data.Format, &event.Data.RowGroup, &event.Data.Checksum, &event.Data.Key)
+	if err != nil {
+		return event, fmt.Errorf("failed to scan cloud event: %w", err)
+	}
+	if extras != "" {
+		if err = json.Unmarshal([]byte(extras), &event.Extras); err != nil {
+			return event, fmt.Errorf("failed to unmarshal extras: %w", err)
+		}
+	}
+	return event, nil
+}
+
+// buildListIndexesQuery builds the query used by ListIndexes for the given table.
+// A limit of zero or less builds a query without a limit, which only the iterators allow.
+func buildListIndexesQuery(table string, limit int, opts *SearchOptions) (string, []any, error) {
 	order := " DESC"
 	if opts != nil && opts.TimestampAsc {
 		order = " ASC"
 	}
 	mods := []qm.QueryMod{
-		qm.Select(chindexer.SubjectColumn,
-			chindexer.TimestampColumn,
-			chindexer.TypeColumn,
-			chindexer.IDColumn,
-			chindexer.SourceColumn,
-			chindexer.ProducerColumn,
-			chindexer.DataContentTypeColumn,
-			chindexer.DataVersionColumn,
-			chindexer.ExtrasColumn,
-			chindexer.IndexKeyColumn,
-		),
-		qm.From(chindexer.TableName),
-		qm.OrderBy(chindexer.TimestampColumn + order),
-		qm.Limit(limit),
+		qm.Select(indexColumns...),
+		qm.From(table),
+		qm.OrderBy(chindexer.TimestampColumn + order + ", " + chindexer.IDColumn + order + ", " + chindexer.IndexKeyColumn + order),
+	}
+	if limit > 0 {
+		mods = append(mods, qm.Limit(limit))
 	}
 
 	optsMods, err := opts.QueryMods()
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	mods = append(mods, optsMods...)
 	query, args := newQuery(mods...)
-	rows, err := s.chConn.Query(ctx, query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get cloud events: %w", err)
+	return query, args, nil
+}
+
+// fromTable returns the table expression to query for the given options.
+func fromTable(opts *SearchOptions) string {
+	if opts != nil && opts.Deduplicate {
+		return chindexer.TableName + " FINAL"
 	}
+	return chindexer.TableName
+}
 
-	var cloudEvents []cloudevent.CloudEvent[ObjectInfo]
-	var extras string
-	for rows.Next() {
-		var event cloudevent.CloudEvent[ObjectInfo]
-		err = rows.Scan(&event.Subject, &event.Time, &event.Type, &event.ID, &event.Source, &event.Producer, &event.DataContentType, &event.DataVersion, &extras, &event.Data.Key)
-		if err != nil {
-			_ = rows.Close()
-			return nil, fmt.Errorf("failed to scan cloud event: %w", err)
+// latestTableCompatible reports whether the latest index for the given options can be read from the latest table.
+// The latest table only holds the newest row per subject, type, source and data version, so it returns the same
+// row as the full table only when filtering on those columns and on a lower time bound.
+func latestTableCompatible(opts *SearchOptions) bool {
+	if opts == nil {
+		return true
+	}
+	rest := *opts
+	rest.After = time.Time{}
+	rest.AfterInclusive = false
+	rest.Window = 0
+	rest.Subject = nil
+	rest.Type = nil
+	rest.Source = nil
+	rest.DataVersion = nil
+	rest.Subjects, rest.NotSubjects = nil, nil
+	rest.Types, rest.NotTypes = nil, nil
+	rest.Sources, rest.NotSources = nil, nil
+	rest.DataVersions, rest.NotDataVersions = nil, nil
+	rest.TimestampAsc = false
+	rest.Deduplicate = false
+	if latestFilterCompatible(opts.Filter, true) {
+		rest.Filter = nil
+	}
+	return reflect.ValueOf(rest).IsZero()
+}
+
+// latestFilterCompatible reports whether the filter only selects rows by the latest table key columns,
+// or by a lower time bound that is not nested in an OR or NOT.
+func latestFilterCompatible(filter Filter, top bool) bool {
+	switch f := filter.(type) {
+	case nil:
+		return true
+	case AndFilter:
+		for _, child := range f {
+			if !latestFilterCompatible(child, top) {
+				return false
+			}
 		}
-		if extras != "" {
-			if err = json.Unmarshal([]byte(extras), &event.Extras); err != nil {
-				_ = rows.Close()
-				return nil, fmt.Errorf("failed to unmarshal extras: %w", err)
+		return true
+	case OrFilter:
+		for _, child := range f {
+			if !latestFilterCompatible(child, false) {
+				return false
 			}
 		}
-		cloudEvents = append(cloudEvents, event)
+		return true
+	case NotFilter:
+		return latestFilterCompatible(f.Filter, false)
+	case EqFilter:
+		return isLatestKeyColumn(f.Column)
+	case InFilter:
+		return isLatestKeyColumn(f.Column)
+	case PrefixFilter:
+		return isLatestKeyColumn(f.Column)
+	case RangeFilter:
+		if f.Column == chindexer.TimestampColumn {
+			return top && f.To == nil
+		}
+		return isLatestKeyColumn(f.Column)
+	default:
+		return false
 	}
-	_ = rows.Close()
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate over cloud events: %w", err)
+}
+
+// isLatestKeyColumn reports whether the column is part of the latest table key.
+func isLatestKeyColumn(column string) bool {
+	switch column {
+	case chindexer.SubjectColumn, chindexer.TypeColumn, chindexer.SourceColumn, chindexer.DataVersionColumn:
+		return true
+	default:
+		return false
 	}
-	if len(cloudEvents) == 0 {
-		return nil, fmt.Errorf("no cloud events found %w", sql.ErrNoRows)
+}
+
+// ListIndexesPage fetches a page of indexes like ListIndexes and returns a cursor for the next page.
+// Pass the cursor back in SearchOptions.Cursor to continue the listing. The cursor is empty when there are no more pages.
+func (s *Service) ListIndexesPage(ctx context.Context, limit int, opts *SearchOptions) ([]cloudevent.CloudEvent[ObjectInfo], string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("no cloud events found %w", sql.ErrNoRows)
 	}
-	return cloudEvents, nil
+	// one more row than the page is fetched to find out whether there is a next page
+	events, err := s.ListIndexes(ctx, limit+1, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	events, cursor := nextCursor(events, limit, opts != nil && opts.TimestampAsc)
+	return events, cursor, nil
 }
 
 // ListCloudEvents fetches and returns the cloud events that match the given options.
@@ -130,6 +406,48 @@ func (s *Service) ListCloudEvents(ctx context.Context, bucketName string, limit
 	return data, nil
 }
 
+// ListCloudEventsPage fetches a page of cloud events like ListCloudEvents and returns a cursor for the next page.
+// Pass the cursor back in SearchOptions.Cursor to continue the listing. The cursor is empty when there are no more pages.
+func (s *Service) ListCloudEventsPage(ctx context.Context, bucketName string, limit int, opts *SearchOptions) ([]cloudevent.CloudEvent[json.RawMessage], string, error) {
+	events, cursor, err := s.ListIndexesPage(ctx, limit, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := s.ListCloudEventsFromIndexes(ctx, events, bucketName)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, cursor, nil
+}
+
+// IterCloudEvents returns an iterator over the cloud events that match the given options.
+// Objects are fetched lazily as iteration advances so only one object is held in memory at a time.
+// Consecutive events stored in the same object reuse the fetched object.
+// A limit of zero or less iterates over all matching cloud events.
+func (s *Service) IterCloudEvents(ctx context.Context, bucketName string, limit int, opts *SearchOptions) iter.Seq2[cloudevent.CloudEvent[json.RawMessage], error] {
+	return func(yield func(cloudevent.CloudEvent[json.RawMessage], error) bool) {
+		var lastRef ObjectInfo
+		var lastObj []byte
+		for index, err := range s.IterIndexes(ctx, limit, opts) {
+			if err != nil {
+				yield(cloudevent.CloudEvent[json.RawMessage]{}, err)
+				return
+			}
+			if lastObj == nil || index.Data != lastRef {
+				lastObj, err = s.GetObjectFromRef(ctx, index.Data, bucketName)
+				if err != nil {
+					yield(cloudevent.CloudEvent[json.RawMessage]{}, err)
+					return
+				}
+				lastRef = index.Data
+			}
+			if !yield(toCloudEvent(&index.CloudEventHeader, lastObj), nil) {
+				return
+			}
+		}
+	}
+}
+
 // GetLatestCloudEvent fetches and returns the latest cloud event that matches the given options.
 func (s *Service) GetLatestCloudEvent(ctx context.Context, bucketName string, opts *SearchOptions) (cloudevent.CloudEvent[json.RawMessage], error) {
 	cloudIdx, err := s.GetLatestIndex(ctx, opts)
@@ -146,28 +464,28 @@ func (s *Service) GetLatestCloudEvent(ctx context.Context, bucketName string, op
 }
 
 // ListCloudEventsFromIndexes fetches and returns the cloud events for the given index.
+// Objects are fetched concurrently and the events are returned in the order of the indexes.
+// The first failed fetch cancels the remaining fetches.
 func (s *Service) ListCloudEventsFromIndexes(ctx context.Context, indexes []cloudevent.CloudEvent[ObjectInfo], bucketName string) ([]cloudevent.CloudEvent[json.RawMessage], error) {
+	// Some objects have multiple cloud events so each distinct object is only fetched once.
+	refs := make([]ObjectInfo, len(indexes))
+	for i := range indexes {
+		refs[i] = indexes[i].Data
+	}
+	objects, _, err := s.fetchObjects(ctx, bucketName, refs, false)
+	if err != nil {
+		return nil, err
+	}
 	events := make([]cloudevent.CloudEvent[json.RawMessage], len(indexes))
-	var err error
-	objectsByKeys := map[string][]byte{}
 	for i := range indexes {
-		// Some objects have multiple cloud events so we cache the objects to avoid fetching them multiple times.
-		if obj, ok := objectsByKeys[indexes[i].Data.Key]; ok {
-			events[i] = cloudevent.CloudEvent[json.RawMessage]{CloudEventHeader: indexes[i].CloudEventHeader, Data: obj}
-			continue
-		}
-		events[i], err = s.GetCloudEventFromIndex(ctx, indexes[i], bucketName)
-		if err != nil {
-			return nil, err
-		}
-		objectsByKeys[indexes[i].Data.Key] = events[i].Data
+		events[i] = toCloudEvent(&indexes[i].CloudEventHeader, objects[refs[i]])
 	}
 	return events, nil
 }
 
 // GetCloudEventFromIndex fetches and returns the cloud event for the given index.
 func (s *Service) GetCloudEventFromIndex(ctx context.Context, index cloudevent.CloudEvent[ObjectInfo], bucketName string) (cloudevent.CloudEvent[json.RawMessage], error) {
-	rawData, err := s.GetObjectFromKey(ctx, index.Data.Key, bucketName)
+	rawData, err := s.GetObjectFromRef(ctx, index.Data, bucketName)
 	if err != nil {
 		return cloudevent.CloudEvent[json.RawMessage]{}, err
 	}
@@ -175,55 +493,133 @@ func (s *Service) GetCloudEventFromIndex(ctx context.Context, index cloudevent.C
 }
 
 // ListObjectsFromKeys fetches and returns the objects for the given keys.
+// Objects are fetched concurrently and returned in the order of the keys.
+// The first failed fetch cancels the remaining fetches.
 func (s *Service) ListObjectsFromKeys(ctx context.Context, keys []string, bucketName string) ([][]byte, error) {
+	objects, _, err := s.fetchObjects(ctx, bucketName, keysToRefs(keys), false)
+	if err != nil {
+		return nil, err
+	}
 	data := make([][]byte, len(keys))
-	var err error
 	for i, key := range keys {
-		data[i], err = s.GetObjectFromKey(ctx, key, bucketName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get data from key '%s': %w", key, err)
-		}
+		data[i] = objects[ObjectInfo{Key: key}]
 	}
 	return data, nil
 }
 
 // GetRawObjectFromKey fetches and returns the raw object for the given key without unmarshalling to a cloud event.
+// Compressed objects are decompressed and objects are served from the object cache when one is configured.
 func (s *Service) GetObjectFromKey(ctx context.Context, key, bucketName string) ([]byte, error) {
-	obj, err := s.objGetter.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(key),
-	})
+	return s.GetObjectFromRef(ctx, ObjectInfo{Key: key}, bucketName)
+}
+
+// GetObjectFromRef fetches and returns the data the object info points to.
+// Cloud events in batch objects are read with a ranged get of their bytes only
+// and cloud events in archive objects are read from their row in the Parquet file.
+// The data is verified against the checksum of the object info or, for whole objects, the checksum in the object metadata.
+func (s *Service) GetObjectFromRef(ctx context.Context, ref ObjectInfo, bucketName string) ([]byte, error) {
+	cacheKey, err := objectCacheKey(ref)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+		return nil, fmt.Errorf("failed to read object '%s': %w", ref.Key, err)
 	}
-	defer obj.Body.Close() //nolint
-
-	data, err := io.ReadAll(obj.Body)
+	if s.cache != nil {
+		if data, ok := s.getCachedObject(ctx, bucketName, cacheKey, ref.Key); ok {
+			if err := s.verifyChecksum(ctx, ref.Key, ref.Checksum, data); err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	}
+	data, info, err := s.readObject(ctx, ref, bucketName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object body: %w", err)
+		return nil, err
+	}
+	checksum := ref.Checksum
+	if checksum == "" && ref.Format == "" && ref.Length == 0 {
+		checksum = info.Metadata[ChecksumMetadataKey]
+	}
+	if err := s.verifyChecksum(ctx, ref.Key, checksum, data); err != nil {
+		return nil, err
+	}
+	if s.cache != nil {
+		s.cache.Set(ctx, bucketName, cacheKey, CachedObject{Data: data, ETag: info.ETag})
 	}
 	return data, nil
 }
 
-// StoreObject stores the given data in S3 with the given cloudevent header.
+// readObject reads the data the object info points to from the blob store.
+// It returns the data and the info of the backing object.
+func (s *Service) readObject(ctx context.Context, ref ObjectInfo, bucketName string) ([]byte, blobstore.BlobInfo, error) {
+	if ref.Format == chindexer.DataFormatParquet {
+		return s.readArchiveRow(ctx, bucketName, ref)
+	}
+	var data []byte
+	var info blobstore.BlobInfo
+	var err error
+	if ref.Length > 0 {
+		data, info, err = s.store.GetRange(ctx, bucketName, ref.Key, int64(ref.Offset), int64(ref.Length))
+	} else {
+		data, info, err = s.store.Get(ctx, bucketName, ref.Key)
+	}
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to get object: %w", err)
+	}
+	if ref.Length > 0 && info.Metadata[ContentEncodingMetadataKey] != "" {
+		return nil, info, fmt.Errorf("failed to read object '%s': ranges of compressed objects can not be read", ref.Key)
+	}
+	data, err = decompress(data, info.Metadata)
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to decompress object '%s': %w", ref.Key, err)
+	}
+	return data, info, nil
+}
+
+// StoreObject stores the given data in the blob store with the given cloudevent header.
+// The data is compressed with the configured compression and its checksum is recorded in the index and the object metadata.
+// With an outbox the write is recorded before the object is stored and only removed once the index is stored,
+// so a write that fails half way is finished or rolled back by RecoverOutbox. Retrying a failed write is safe either way.
 func (s *Service) StoreObject(ctx context.Context, bucketName string, cloudHeader *cloudevent.CloudEventHeader, data []byte) error {
 	key := nameindexer.CloudEventToIndexKey(cloudHeader)
-	_, err := s.objGetter.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: &bucketName,
-		Key:    &key,
-		Body:   bytes.NewReader(data),
-	})
+	checksum := chindexer.DataChecksum(data)
+	stored, metadata, err := compress(s.compression, data)
+	if err != nil {
+		return err
+	}
+	metadata = withChecksumMetadata(metadata, checksum)
+	var entry OutboxEntry
+	if s.outbox != nil {
+		entry = OutboxEntry{
+			ID:       outboxID(bucketName, key, checksum),
+			Bucket:   bucketName,
+			Key:      key,
+			Checksum: checksum,
+			Header:   *cloudHeader,
+			Created:  s.now(),
+		}
+		if err := s.outbox.Add(ctx, entry); err != nil {
+			return fmt.Errorf("failed to record write in outbox: %w", err)
+		}
+	}
+	err = s.store.Put(ctx, bucketName, key, stored, metadata)
+	s.invalidateCachedObject(ctx, bucketName, key)
 	if err != nil {
-		return fmt.Errorf("failed to store object in S3: %w", err)
+		return fmt.Errorf("failed to store object: %w", err)
 	}
 
-	values := chindexer.CloudEventToSlice(cloudHeader)
+	values := chindexer.CloudEventToSliceWithRef(cloudHeader, chindexer.ObjectRef{Key: key, Checksum: checksum})
 
 	err = s.chConn.Exec(ctx, chindexer.InsertStmt, values...)
 	if err != nil {
 		return fmt.Errorf("failed to store index in ClickHouse: %w", err)
 	}
 
+	if s.outbox != nil {
+		if err := s.outbox.Remove(ctx, entry.ID); err != nil {
+			// the write is complete, recovery only stores the same index again
+			s.logger.WarnContext(ctx, "failed to remove outbox entry", "key", key, "error", err)
+		}
+	}
+
 	return nil
 }
 
@@ -245,8 +641,15 @@ func toCloudEvent(dbHdr *cloudevent.CloudEventHeader, data []byte) cloudevent.Cl
 type SearchOptions struct {
 	// After if set only objects after this time are returned.
 	After time.Time
+	// AfterInclusive if set objects at exactly the After time are also returned.
+	AfterInclusive bool
 	// Before if set only objects before this time are returned.
 	Before time.Time
+	// BeforeInclusive if set objects at exactly the Before time are also returned.
+	BeforeInclusive bool
+	// Window if set only objects from the last Window are returned, including the start of the window.
+	// The window is resolved against the service clock and can not be combined with After.
+	Window time.Duration
 	// Subject if set only objects for this subject are returned.
 	Subject *string
 	// TimestampAsc if set objects are queried and returned in ascending order by timestamp.
@@ -266,40 +669,161 @@ type SearchOptions struct {
 	DataContentType *string
 	// Extras is the extra metadata for the cloud event.
 	Extras *string
+	// ExtrasKeys if set only objects whose extras contain all of these keys are returned.
+	ExtrasKeys []string
+	// ExtrasMatch if set only objects whose extras contain all of these key value pairs are returned.
+	// Non-string extras values are matched against their JSON encoding.
+	ExtrasMatch map[string]string
 	// IndexKey is the key of the backing object for this cloud event.
 	IndexKey *string
+	// Subjects if not empty only objects for one of these subjects are returned.
+	Subjects []string
+	// Types if not empty only objects with one of these types are returned.
+	Types []string
+	// Sources if not empty only objects from one of these sources are returned.
+	Sources []string
+	// Producers if not empty only objects from one of these producers are returned.
+	Producers []string
+	// DataVersions if not empty only objects with one of these data versions are returned.
+	DataVersions []string
+	// NotSubjects if not empty objects for these subjects are excluded.
+	NotSubjects []string
+	// NotTypes if not empty objects with these types are excluded.
+	NotTypes []string
+	// NotSources if not empty objects from these sources are excluded.
+	NotSources []string
+	// NotProducers if not empty objects from these producers are excluded.
+	NotProducers []string
+	// NotDataVersions if not empty objects with these data versions are excluded.
+	NotDataVersions []string
+	// Filter if set only objects matching this filter are returned.
+	// It is combined with the other options using AND.
+	Filter Filter
+	// Cursor if set only objects after this position in the listing are returned.
+	// Cursors are returned by ListIndexesPage and ListCloudEventsPage and must be used with the same sort order.
+	Cursor string
+	// Deduplicate if set the table is queried with FINAL so rows stored more than once
+	// with the same subject, timestamp, ID and source are returned only once.
+	// This makes results safe against replays and retries at the cost of a slower query.
+	Deduplicate bool
 }
 
+// Validate returns an error if the time bounds of the options can not match any cloud event.
+func (o *SearchOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Window < 0 {
+		return fmt.Errorf("%w: window %s is negative", ErrInvalidTimeRange, o.Window)
+	}
+	if o.Window > 0 && !o.After.IsZero() {
+		return fmt.Errorf("%w: window can not be combined with after", ErrInvalidTimeRange)
+	}
+	if o.After.IsZero() || o.Before.IsZero() {
+		return nil
+	}
+	if o.After.After(o.Before) || (o.After.Equal(o.Before) && !(o.AfterInclusive && o.BeforeInclusive)) {
+		return fmt.Errorf("%w: after %s is not before %s", ErrInvalidTimeRange, o.After.Format(time.RFC3339Nano), o.Before.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// QueryMods returns the query mods for the options.
+// A relative Window is resolved against the current time, use the Service methods to resolve it against the service clock.
 func (o *SearchOptions) QueryMods() ([]qm.QueryMod, error) {
 	if o == nil {
 		return nil, nil
 	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
 	var mods []qm.QueryMod
+	if filter := o.ToFilter(); len(filter) > 0 {
+		filterMod, err := filterQueryMod(filter)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, filterMod)
+	}
+	if o.Cursor != "" {
+		cursorMod, err := cursorQueryMod(o.Cursor, o.TimestampAsc)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, cursorMod)
+	}
+	return mods, nil
+}
+
+// ToFilter converts the options to a filter that matches the same cloud events.
+// The cursor, sort order and deduplication are not part of the filter.
+func (o *SearchOptions) ToFilter() AndFilter {
+	if o == nil {
+		return nil
+	}
+	var filters AndFilter
 	if !o.After.IsZero() {
-		mods = append(mods, qm.Where(chindexer.TimestampColumn+" > ?", o.After))
+		filters = append(filters, RangeFilter{Column: chindexer.TimestampColumn, From: o.After, FromInclusive: o.AfterInclusive})
+	} else if o.Window > 0 {
+		filters = append(filters, RangeFilter{Column: chindexer.TimestampColumn, From: time.Now().Add(-o.Window), FromInclusive: true})
 	}
 	if !o.Before.IsZero() {
-		mods = append(mods, qm.Where(chindexer.TimestampColumn+" < ?", o.Before))
+		filters = append(filters, RangeFilter{Column: chindexer.TimestampColumn, To: o.Before, ToInclusive: o.BeforeInclusive})
 	}
-	if o.Type != nil {
-		mods = append(mods, qm.Where(chindexer.TypeColumn+" = ?", *o.Type))
+	eqFilters := []struct {
+		column string
+		value  *string
+	}{
+		{chindexer.TypeColumn, o.Type},
+		{chindexer.DataVersionColumn, o.DataVersion},
+		{chindexer.SubjectColumn, o.Subject},
+		{chindexer.SourceColumn, o.Source},
+		{chindexer.ProducerColumn, o.Producer},
+		{chindexer.IDColumn, o.ID},
+		{chindexer.DataContentTypeColumn, o.DataContentType},
+		{chindexer.IndexKeyColumn, o.IndexKey},
+		{chindexer.ExtrasColumn, o.Extras},
 	}
-	if o.DataVersion != nil {
-		mods = append(mods, qm.Where(chindexer.DataVersionColumn+" = ?", *o.DataVersion))
+	for _, eq := range eqFilters {
+		if eq.value != nil {
+			filters = append(filters, Eq(eq.column, *eq.value))
+		}
 	}
-	if o.Subject != nil {
-		mods = append(mods, qm.Where(chindexer.SubjectColumn+" = ?", *o.Subject))
+	inFilters := []struct {
+		column string
+		in     []string
+		notIn  []string
+	}{
+		{chindexer.SubjectColumn, o.Subjects, o.NotSubjects},
+		{chindexer.TypeColumn, o.Types, o.NotTypes},
+		{chindexer.SourceColumn, o.Sources, o.NotSources},
+		{chindexer.ProducerColumn, o.Producers, o.NotProducers},
+		{chindexer.DataVersionColumn, o.DataVersions, o.NotDataVersions},
 	}
-	if o.Source != nil {
-		mods = append(mods, qm.Where(chindexer.SourceColumn+" = ?", *o.Source))
+	for _, in := range inFilters {
+		if len(in.in) > 0 {
+			filters = append(filters, In(in.column, in.in...))
+		}
+		if len(in.notIn) > 0 {
+			filters = append(filters, Not(In(in.column, in.notIn...)))
+		}
 	}
-	if o.Producer != nil {
-		mods = append(mods, qm.Where(chindexer.ProducerColumn+" = ?", *o.Producer))
+	for _, key := range o.ExtrasKeys {
+		filters = append(filters, ExtrasHasKey(key))
 	}
-	if o.Extras != nil {
-		mods = append(mods, qm.Where(chindexer.ExtrasColumn+" = ?", *o.Extras))
+	// sort the keys so the generated query is deterministic
+	matchKeys := make([]string, 0, len(o.ExtrasMatch))
+	for key := range o.ExtrasMatch {
+		matchKeys = append(matchKeys, key)
 	}
-	return mods, nil
+	slices.Sort(matchKeys)
+	for _, key := range matchKeys {
+		filters = append(filters, ExtrasEq(key, o.ExtrasMatch[key]))
+	}
+	if o.Filter != nil {
+		filters = append(filters, o.Filter)
+	}
+	return filters
 }
 
 var dialect = drivers.Dialect{
@@ -21,6 +21,7 @@ import (
 type MockObjectGetter struct {
 	ctrl     *gomock.Controller
 	recorder *MockObjectGetterMockRecorder
+	isgomock struct{}
 }
 
 // MockObjectGetterMockRecorder is the mock recorder for MockObjectGetter.
@@ -79,3 +80,47 @@ func (mr *MockObjectGetterMockRecorder) PutObject(ctx, params any, optFns ...any
 	varargs := append([]any{ctx, params}, optFns...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutObject", reflect.TypeOf((*MockObjectGetter)(nil).PutObject), varargs...)
 }
+
+// MockObjectDeleter is a mock of ObjectDeleter interface.
+type MockObjectDeleter struct {
+	ctrl     *gomock.Controller
+	recorder *MockObjectDeleterMockRecorder
+	isgomock struct{}
+}
+
+// MockObjectDeleterMockRecorder is the mock recorder for MockObjectDeleter.
+type MockObjectDeleterMockRecorder struct {
+	mock *MockObjectDeleter
+}
+
+// NewMockObjectDeleter creates a new mock instance.
+func NewMockObjectDeleter(ctrl *gomock.Controller) *MockObjectDeleter {
+	mock := &MockObjectDeleter{ctrl: ctrl}
+	mock.recorder = &MockObjectDeleterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockObjectDeleter) EXPECT() *MockObjectDeleterMockRecorder {
+	return m.recorder
+}
+
+// DeleteObject mocks base method.
+func (m *MockObjectDeleter) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, params}
+	for _, a := range optFns {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DeleteObject", varargs...)
+	ret0, _ := ret[0].(*s3.DeleteObjectOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteObject indicates an expected call of DeleteObject.
+func (mr *MockObjectDeleterMockRecorder) DeleteObject(ctx, params any, optFns ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, params}, optFns...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteObject", reflect.TypeOf((*MockObjectDeleter)(nil).DeleteObject), varargs...)
+}
@@ -4,9 +4,12 @@ package indexrepo_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/DIMO-Network/clickhouse-infra/pkg/container"
 	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
 	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
 	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
 	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
 	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/migrations"
@@ -357,6 +361,65 @@ func TestGetData(t *testing.T) {
 			opts:              nil,
 			expectedIndexKeys: []string{indexKey4, indexKey3, indexKey2, indexKey1},
 		},
+		{
+			name: "data with index key filter",
+			opts: &indexrepo.SearchOptions{
+				IndexKey: &indexKey3,
+			},
+			expectedIndexKeys: []string{indexKey3},
+		},
+		{
+			name: "data with data content type filter",
+			opts: &indexrepo.SearchOptions{
+				DataContentType: ref("utf-8"),
+			},
+			expectedIndexKeys: []string{indexKey4},
+		},
+		{
+			name: "data with id filter",
+			opts: &indexrepo.SearchOptions{
+				ID: ref("missing"),
+			},
+			expectedError: true,
+		},
+		{
+			name: "data with multiple subjects",
+			opts: &indexrepo.SearchOptions{
+				Subjects: []string{eventDID.String(), cloudevent.NFTDID{
+					ChainID:         153,
+					ContractAddress: contractAddr,
+					TokenID:         device2TokenID,
+				}.String()},
+			},
+			expectedIndexKeys: []string{indexKey4, indexKey3, indexKey2, indexKey1},
+		},
+		{
+			name: "data with multiple types",
+			opts: &indexrepo.SearchOptions{
+				Types: []string{cloudevent.TypeFingerprint, cloudevent.TypeVerifableCredential},
+			},
+			expectedIndexKeys: []string{indexKey3},
+		},
+		{
+			name: "data with filter expression",
+			opts: &indexrepo.SearchOptions{
+				Filter: indexrepo.Or(
+					indexrepo.Eq(chindexer.TypeColumn, cloudevent.TypeFingerprint),
+					indexrepo.And(
+						indexrepo.Eq(chindexer.TypeColumn, cloudevent.TypeStatus),
+						indexrepo.Eq(chindexer.DataContentTypeColumn, "utf-8"),
+					),
+				),
+			},
+			expectedIndexKeys: []string{indexKey4, indexKey3},
+		},
+		{
+			name: "data with excluded types",
+			opts: &indexrepo.SearchOptions{
+				NotTypes: []string{cloudevent.TypeFingerprint},
+			},
+			expectedIndexKeys: []string{indexKey4, indexKey2, indexKey1},
+		},
 	}
 
 	for _, tt := range tests {
@@ -393,6 +456,503 @@ func TestGetData(t *testing.T) {
 	}
 }
 
+// TestExtrasFilters tests filtering on the extras map column.
+func TestExtrasFilters(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	eventIdx1 := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-2 * time.Hour),
+		DataVersion: dataType,
+		Extras:      map[string]any{"vin": "1HGCM82633A123456", "odometer": 10},
+	}
+	indexKey1 := insertTestData(t, ctx, conn, eventIdx1)
+	eventIdx2 := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-1 * time.Hour),
+		DataVersion: dataType,
+		Extras:      map[string]any{"vin": "5YJSA1E26JF123456"},
+	}
+	indexKey2 := insertTestData(t, ctx, conn, eventIdx2)
+
+	tests := []struct {
+		name              string
+		opts              *indexrepo.SearchOptions
+		expectedIndexKeys []string
+	}{
+		{
+			name:              "key exists",
+			opts:              &indexrepo.SearchOptions{Subject: &subject, ExtrasKeys: []string{"vin"}},
+			expectedIndexKeys: []string{indexKey2, indexKey1},
+		},
+		{
+			name:              "key exists on one event",
+			opts:              &indexrepo.SearchOptions{Subject: &subject, ExtrasKeys: []string{"odometer"}},
+			expectedIndexKeys: []string{indexKey1},
+		},
+		{
+			name:              "key value match",
+			opts:              &indexrepo.SearchOptions{Subject: &subject, ExtrasMatch: map[string]string{"vin": "5YJSA1E26JF123456"}},
+			expectedIndexKeys: []string{indexKey2},
+		},
+		{
+			name:              "non string value match",
+			opts:              &indexrepo.SearchOptions{Subject: &subject, ExtrasMatch: map[string]string{"odometer": "10"}},
+			expectedIndexKeys: []string{indexKey1},
+		},
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := indexService.ListIndexes(ctx, 10, tt.opts)
+			require.NoError(t, err)
+			keys := make([]string, len(events))
+			for i := range events {
+				keys[i] = events[i].Data.Key
+			}
+			require.Equal(t, tt.expectedIndexKeys, keys)
+		})
+	}
+}
+
+// TestSkipIndexesUsed tests that lookups without a subject use the data skipping indexes.
+func TestSkipIndexesUsed(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	eventIdx := &cloudevent.CloudEventHeader{
+		Subject: cloudevent.NFTDID{
+			ChainID:         153,
+			ContractAddress: randAddress(),
+			TokenID:         123456,
+		}.String(),
+		Time:        time.Now(),
+		Source:      randAddress().Hex(),
+		Producer:    "producer",
+		DataVersion: dataType,
+	}
+	_ = insertTestData(t, ctx, conn, eventIdx)
+
+	tests := []struct {
+		name          string
+		opts          *indexrepo.SearchOptions
+		expectedIndex string
+	}{
+		{
+			name:          "source",
+			opts:          &indexrepo.SearchOptions{Source: &eventIdx.Source},
+			expectedIndex: "idx_source",
+		},
+		{
+			name:          "producer",
+			opts:          &indexrepo.SearchOptions{Producer: &eventIdx.Producer},
+			expectedIndex: "idx_producer",
+		},
+		{
+			name:          "id",
+			opts:          &indexrepo.SearchOptions{ID: ref("event-1")},
+			expectedIndex: "idx_id",
+		},
+		{
+			name:          "index key",
+			opts:          &indexrepo.SearchOptions{IndexKey: ref("key")},
+			expectedIndex: "idx_index_key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := indexrepo.BuildListIndexesQuery(chindexer.TableName, 10, tt.opts)
+			require.NoError(t, err)
+			rows, err := conn.Query(ctx, "EXPLAIN indexes = 1 "+query, args...)
+			require.NoError(t, err)
+			defer rows.Close() //nolint
+			var plan strings.Builder
+			for rows.Next() {
+				var line string
+				require.NoError(t, rows.Scan(&line))
+				plan.WriteString(line + "\n")
+			}
+			require.NoError(t, rows.Err())
+			require.Contains(t, plan.String(), "Name: "+tt.expectedIndex)
+		})
+	}
+}
+
+// TestDeduplicate tests that replayed cloud events are returned once when deduplicating.
+func TestDeduplicate(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	eventIdx := &cloudevent.CloudEventHeader{
+		ID:          "event-1",
+		Subject:     subject,
+		Time:        time.Now(),
+		Source:      randAddress().Hex(),
+		DataVersion: dataType,
+	}
+	// insert the same event twice to simulate a replay
+	indexKey := insertTestData(t, ctx, conn, eventIdx)
+	_ = insertTestData(t, ctx, conn, eventIdx)
+
+	indexService := indexrepo.New(conn, nil)
+	events, err := indexService.ListIndexes(ctx, 10, &indexrepo.SearchOptions{Subject: &subject, Deduplicate: true})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, indexKey, events[0].Data.Key)
+}
+
+// TestDeduplicateEmptyID tests distinct events without an ID in the same millisecond survive merges.
+func TestDeduplicateEmptyID(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	eventTime := time.Now().UTC().Truncate(time.Millisecond)
+	source := randAddress().Hex()
+	// events without an ID that only differ in their data version are stored in different objects
+	firstIdx := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        eventTime,
+		Type:        cloudevent.TypeStatus,
+		Source:      source,
+		DataVersion: dataType,
+	}
+	secondIdx := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        eventTime,
+		Type:        cloudevent.TypeStatus,
+		Source:      source,
+		DataVersion: dataType + "-v2",
+	}
+	firstKey := insertTestData(t, ctx, conn, firstIdx)
+	secondKey := insertTestData(t, ctx, conn, secondIdx)
+	// a replay of an event without an ID is still merged
+	_ = insertTestData(t, ctx, conn, firstIdx)
+	require.NoError(t, conn.Exec(ctx, "OPTIMIZE TABLE "+chindexer.TableName+" FINAL"))
+
+	indexService := indexrepo.New(conn, nil)
+	events, err := indexService.ListIndexes(ctx, 10, &indexrepo.SearchOptions{Subject: &subject})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.ElementsMatch(t, []string{firstKey, secondKey}, []string{events[0].Data.Key, events[1].Data.Key})
+	for _, event := range events {
+		require.Empty(t, event.ID)
+	}
+}
+
+// TestGetLatestIndexFallback tests the latest index is correct when the latest table can not be used.
+func TestGetLatestIndexFallback(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	eventIdx1 := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-2 * time.Hour),
+		DataVersion: dataType,
+		Producer:    "producer1",
+	}
+	indexKey1 := insertTestData(t, ctx, conn, eventIdx1)
+	eventIdx2 := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-1 * time.Hour),
+		DataVersion: dataType,
+		Producer:    "producer2",
+	}
+	indexKey2 := insertTestData(t, ctx, conn, eventIdx2)
+
+	tests := []struct {
+		name        string
+		opts        *indexrepo.SearchOptions
+		expectedKey string
+	}{
+		{
+			name:        "latest table",
+			opts:        &indexrepo.SearchOptions{Subject: &subject, After: now.Add(-3 * time.Hour)},
+			expectedKey: indexKey2,
+		},
+		{
+			name:        "before bound",
+			opts:        &indexrepo.SearchOptions{Subject: &subject, Before: now.Add(-90 * time.Minute)},
+			expectedKey: indexKey1,
+		},
+		{
+			name:        "producer filter",
+			opts:        &indexrepo.SearchOptions{Subject: &subject, Producer: ref("producer1")},
+			expectedKey: indexKey1,
+		},
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := indexService.GetLatestIndex(ctx, tt.opts)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedKey, event.Data.Key)
+		})
+	}
+}
+
+func TestLatestTableCompatible(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     *indexrepo.SearchOptions
+		expected bool
+	}{
+		{name: "nil options", opts: nil, expected: true},
+		{name: "latest key columns", opts: &indexrepo.SearchOptions{Subject: ref("s"), Type: ref("t"), Source: ref("src"), DataVersion: ref("v"), After: time.Now()}, expected: true},
+		{name: "window", opts: &indexrepo.SearchOptions{Subject: ref("s"), Window: time.Hour, AfterInclusive: true}, expected: true},
+		{name: "before bound", opts: &indexrepo.SearchOptions{Subject: ref("s"), Before: time.Now()}, expected: false},
+		{name: "producer", opts: &indexrepo.SearchOptions{Producer: ref("p")}, expected: false},
+		{name: "multiple subjects", opts: &indexrepo.SearchOptions{Subjects: []string{"a", "b"}, NotTypes: []string{"t"}}, expected: true},
+		{name: "multiple producers", opts: &indexrepo.SearchOptions{Producers: []string{"a", "b"}}, expected: false},
+		{name: "filter on key columns", opts: &indexrepo.SearchOptions{Filter: indexrepo.Or(indexrepo.Eq(chindexer.TypeColumn, "t"), indexrepo.Eq(chindexer.SourceColumn, "s"))}, expected: true},
+		{name: "filter with lower time bound", opts: &indexrepo.SearchOptions{Filter: indexrepo.Range(chindexer.TimestampColumn, time.Now(), nil)}, expected: true},
+		{name: "filter with upper time bound", opts: &indexrepo.SearchOptions{Filter: indexrepo.Range(chindexer.TimestampColumn, nil, time.Now())}, expected: false},
+		{name: "filter with negated time bound", opts: &indexrepo.SearchOptions{Filter: indexrepo.Not(indexrepo.Range(chindexer.TimestampColumn, time.Now(), nil))}, expected: false},
+		{name: "extras match", opts: &indexrepo.SearchOptions{ExtrasMatch: map[string]string{"a": "b"}}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, indexrepo.LatestTableCompatible(tt.opts))
+		})
+	}
+}
+
+// TestIterCloudEvents tests streaming cloud events and stopping early.
+func TestIterCloudEvents(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	var expectedKeys []string
+	for i := range 3 {
+		eventIdx := &cloudevent.CloudEventHeader{
+			Subject:     subject,
+			Time:        now.Add(-time.Duration(i) * time.Hour),
+			DataVersion: dataType,
+		}
+		expectedKeys = append(expectedKeys, insertTestData(t, ctx, conn, eventIdx))
+	}
+
+	ctrl := gomock.NewController(t)
+	mockS3Client := NewMockObjectGetter(ctrl)
+	mockS3Client.EXPECT().GetObject(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+		quotedKey := `"` + *params.Key + `"`
+		return &s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader([]byte(quotedKey))),
+		}, nil
+	}).Times(2)
+
+	indexService := indexrepo.New(conn, mockS3Client)
+	var keys []string
+	for event, err := range indexService.IterCloudEvents(ctx, "test-bucket", 0, &indexrepo.SearchOptions{Subject: &subject}) {
+		require.NoError(t, err)
+		keys = append(keys, string(event.Data))
+		if len(keys) == 2 {
+			// stop early, the last object must not be fetched
+			break
+		}
+	}
+	require.Equal(t, []string{`"` + expectedKeys[0] + `"`, `"` + expectedKeys[1] + `"`}, keys)
+}
+
+// TestListIndexesInvalidLimit tests the list functions do not query the whole table without a limit.
+func TestListIndexesInvalidLimit(t *testing.T) {
+	ctx := context.Background()
+	indexService := indexrepo.New(nil, nil)
+	for _, limit := range []int{0, -1} {
+		_, err := indexService.ListIndexes(ctx, limit, nil)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		events, cursor, err := indexService.ListIndexesPage(ctx, limit, nil)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.Empty(t, events)
+		require.Empty(t, cursor)
+		_, err = indexService.ListCloudEvents(ctx, "bucket", limit, nil)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	}
+}
+
+// TestTimeBounds tests inclusive bounds and relative windows resolved against the service clock.
+func TestTimeBounds(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+	for _, age := range []time.Duration{48 * time.Hour, 24 * time.Hour, time.Hour} {
+		_ = insertTestData(t, ctx, conn, &cloudevent.CloudEventHeader{Subject: subject, Time: now.Add(-age), DataVersion: dataType})
+	}
+
+	indexService := indexrepo.New(conn, nil, indexrepo.WithClock(func() time.Time { return now }))
+	tests := []struct {
+		name          string
+		opts          indexrepo.SearchOptions
+		expectedCount int
+	}{
+		{name: "exclusive after", opts: indexrepo.SearchOptions{After: now.Add(-24 * time.Hour)}, expectedCount: 1},
+		{name: "inclusive after", opts: indexrepo.SearchOptions{After: now.Add(-24 * time.Hour), AfterInclusive: true}, expectedCount: 2},
+		{name: "exclusive before", opts: indexrepo.SearchOptions{Before: now.Add(-24 * time.Hour)}, expectedCount: 1},
+		{name: "inclusive before", opts: indexrepo.SearchOptions{Before: now.Add(-24 * time.Hour), BeforeInclusive: true}, expectedCount: 2},
+		{name: "window", opts: indexrepo.SearchOptions{Window: 24 * time.Hour}, expectedCount: 2},
+		{name: "short window", opts: indexrepo.SearchOptions{Window: 2 * time.Hour}, expectedCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Subject = &subject
+			indexes, err := indexService.ListIndexes(ctx, 10, &opts)
+			require.NoError(t, err)
+			require.Len(t, indexes, tt.expectedCount)
+		})
+	}
+
+	_, err = indexService.ListIndexes(ctx, 10, &indexrepo.SearchOptions{Subject: &subject, After: now, Before: now.Add(-time.Hour)})
+	require.ErrorIs(t, err, indexrepo.ErrInvalidTimeRange)
+}
+
+func TestSearchOptionsValidate(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		opts          *indexrepo.SearchOptions
+		expectedError bool
+	}{
+		{name: "nil", opts: nil},
+		{name: "no bounds", opts: &indexrepo.SearchOptions{}},
+		{name: "valid range", opts: &indexrepo.SearchOptions{After: ts, Before: ts.Add(time.Hour)}},
+		{name: "inverted range", opts: &indexrepo.SearchOptions{After: ts.Add(time.Hour), Before: ts}, expectedError: true},
+		{name: "empty exclusive range", opts: &indexrepo.SearchOptions{After: ts, Before: ts, AfterInclusive: true}, expectedError: true},
+		{name: "single instant", opts: &indexrepo.SearchOptions{After: ts, Before: ts, AfterInclusive: true, BeforeInclusive: true}},
+		{name: "negative window", opts: &indexrepo.SearchOptions{Window: -time.Hour}, expectedError: true},
+		{name: "window and after", opts: &indexrepo.SearchOptions{Window: time.Hour, After: ts}, expectedError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.expectedError {
+				require.ErrorIs(t, err, indexrepo.ErrInvalidTimeRange)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestObjectGetterOptionalMethods tests that features beyond get and put fail cleanly for a plain ObjectGetter.
+func TestObjectGetterOptionalMethods(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	indexService := indexrepo.New(nil, NewMockObjectGetter(ctrl))
+	_, err := indexService.Rebuild(ctx, "bucket", indexrepo.RebuildOptions{})
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+
+	// deleting expired objects fails before querying ClickHouse when the getter can not head and delete objects
+	policies := []chindexer.RetentionPolicy{{EventType: cloudevent.TypeStatus, Retention: time.Hour}}
+	_, err = indexService.DeleteExpiredObjects(ctx, "bucket", policies, time.Now())
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+	require.ErrorContains(t, err, "DeleteObject and HeadObject")
+	deleteOnly := indexrepo.New(nil, struct {
+		*MockObjectGetter
+		*MockObjectDeleter
+	}{NewMockObjectGetter(ctrl), NewMockObjectDeleter(ctrl)})
+	_, err = deleteOnly.DeleteExpiredObjects(ctx, "bucket", policies, time.Now())
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+	require.ErrorContains(t, err, "HeadObject")
+	require.NotContains(t, err.Error(), "DeleteObject")
+
+	// the full S3 client is accepted without an adapter
+	_ = indexrepo.NewWithS3Client(nil, s3.New(s3.Options{}))
+}
+
+// TestBlobStoreService tests storing and fetching cloud events through a non S3 blob store.
+func TestBlobStoreService(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	store := blobstore.NewMemory()
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+
+	content := []byte(`{"vin": "1HGCM82633A123456"}`)
+	header := cloudevent.CloudEventHeader{
+		Subject: cloudevent.NFTDID{
+			ChainID:         153,
+			ContractAddress: randAddress(),
+			TokenID:         123456,
+		}.String(),
+		Time:        time.Now(),
+		DataVersion: dataType,
+	}
+	err = indexService.StoreObject(ctx, "test-bucket", &header, content)
+	require.NoError(t, err)
+
+	stored, _, err := store.Get(ctx, "test-bucket", nameindexer.CloudEventToIndexKey(&header))
+	require.NoError(t, err)
+	require.Equal(t, content, stored)
+
+	event, err := indexService.GetLatestCloudEvent(ctx, "test-bucket", &indexrepo.SearchOptions{Subject: &header.Subject})
+	require.NoError(t, err)
+	require.JSONEq(t, string(content), string(event.Data))
+
+	_, err = indexService.GetObjectFromKey(ctx, "missing", "test-bucket")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+}
+
 func ref[T any](x T) *T {
 	return &x
 }
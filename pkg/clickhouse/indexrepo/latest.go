@@ -0,0 +1,107 @@
+package indexrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"golang.org/x/sync/errgroup"
+)
+
+// GetLatestIndexes returns the latest cloud event index for each of the given subjects in a single query.
+// The other options are applied to every subject. Subjects without a matching index are not in the result.
+func (s *Service) GetLatestIndexes(ctx context.Context, subjects []string, opts *SearchOptions) (map[string]cloudevent.CloudEvent[ObjectInfo], error) {
+	latest := make(map[string]cloudevent.CloudEvent[ObjectInfo], len(subjects))
+	if len(subjects) == 0 {
+		return latest, nil
+	}
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	subjectOpts := SearchOptions{}
+	if opts != nil {
+		subjectOpts = *opts
+	}
+	// the cursor and sort order only apply to listings
+	subjectOpts.Cursor = ""
+	subjectOpts.TimestampAsc = false
+	subjectOpts.Filter = And(In(chindexer.SubjectColumn, subjects...), subjectOpts.Filter)
+
+	table := fromTable(&subjectOpts)
+	if latestTableCompatible(&subjectOpts) {
+		table = chindexer.LatestTableName
+	}
+	query, args, err := buildLatestIndexesQuery(table, &subjectOpts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.chConn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest cloud events: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+	for rows.Next() {
+		event, err := scanIndex(rows)
+		if err != nil {
+			return nil, err
+		}
+		latest[event.Subject] = event
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over latest cloud events: %w", err)
+	}
+	return latest, nil
+}
+
+// buildLatestIndexesQuery builds the query used by GetLatestIndexes for the given table.
+func buildLatestIndexesQuery(table string, opts *SearchOptions) (string, []any, error) {
+	mods := []qm.QueryMod{
+		qm.Select(indexColumns...),
+		qm.From(table),
+		// sqlboiler has no LIMIT BY clause, it is placed after the ORDER BY which is where ClickHouse expects it.
+		qm.OrderBy(chindexer.SubjectColumn + ", " + chindexer.TimestampColumn + " DESC, " + chindexer.IDColumn + " DESC, " +
+			chindexer.IndexKeyColumn + " DESC LIMIT 1 BY " + chindexer.SubjectColumn),
+	}
+	optsMods, err := opts.QueryMods()
+	if err != nil {
+		return "", nil, err
+	}
+	mods = append(mods, optsMods...)
+	query, args := newQuery(mods...)
+	return query, args, nil
+}
+
+// GetLatestCloudEvents returns the latest cloud event for each of the given subjects.
+// The indexes are fetched in a single query and the objects are fetched concurrently.
+// Subjects without a matching index are not in the result.
+func (s *Service) GetLatestCloudEvents(ctx context.Context, bucketName string, subjects []string, opts *SearchOptions) (map[string]cloudevent.CloudEvent[json.RawMessage], error) {
+	indexes, err := s.GetLatestIndexes(ctx, subjects, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := make(map[string]cloudevent.CloudEvent[json.RawMessage], len(indexes))
+	var mu sync.Mutex
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.fetchConcurrency)
+	for subject, index := range indexes {
+		group.Go(func() error {
+			event, err := s.GetCloudEventFromIndex(groupCtx, index, bucketName)
+			if err != nil {
+				return fmt.Errorf("failed to get latest cloud event for subject '%s': %w", subject, err)
+			}
+			mu.Lock()
+			events[subject] = event
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
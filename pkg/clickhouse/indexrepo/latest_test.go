@@ -0,0 +1,71 @@
+package indexrepo_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// TestGetLatestIndexes tests fetching the latest index and cloud event for many subjects at once.
+func TestGetLatestIndexes(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	contractAddr := randAddress()
+
+	expectedKeys := map[string]string{}
+	var subjects []string
+	for tokenID := range uint32(3) {
+		subject := cloudevent.NFTDID{ChainID: 153, ContractAddress: contractAddr, TokenID: tokenID}.String()
+		subjects = append(subjects, subject)
+		for i := range 3 {
+			eventIdx := &cloudevent.CloudEventHeader{
+				Subject:     subject,
+				Time:        now.Add(-time.Duration(i) * time.Hour),
+				Type:        cloudevent.TypeStatus,
+				DataVersion: dataType,
+			}
+			key := insertTestData(t, ctx, conn, eventIdx)
+			if i == 0 {
+				expectedKeys[subject] = key
+			}
+		}
+	}
+	missingSubject := cloudevent.NFTDID{ChainID: 153, ContractAddress: contractAddr, TokenID: 99}.String()
+
+	ctrl := gomock.NewController(t)
+	mockS3Client := NewMockObjectGetter(ctrl)
+	mockS3Client.EXPECT().GetObject(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+		quotedKey := `"` + *params.Key + `"`
+		return &s3.GetObjectOutput{
+			Body: io.NopCloser(bytes.NewReader([]byte(quotedKey))),
+		}, nil
+	}).Times(len(subjects))
+
+	indexService := indexrepo.New(conn, mockS3Client)
+	opts := &indexrepo.SearchOptions{Type: ref(cloudevent.TypeStatus)}
+	indexes, err := indexService.GetLatestIndexes(ctx, append(subjects, missingSubject), opts)
+	require.NoError(t, err)
+	require.Len(t, indexes, len(subjects))
+	for subject, key := range expectedKeys {
+		require.Equal(t, key, indexes[subject].Data.Key)
+	}
+
+	events, err := indexService.GetLatestCloudEvents(ctx, "test-bucket", subjects, opts)
+	require.NoError(t, err)
+	require.Len(t, events, len(subjects))
+	for subject, key := range expectedKeys {
+		require.Equal(t, `"`+key+`"`, string(events[subject].Data))
+	}
+}
@@ -0,0 +1,72 @@
+package indexrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectHeadClient is the S3 client method for reading the metadata of an object.
+type objectHeadClient interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// objectListClient is the S3 client method for listing objects.
+type objectListClient interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// objectGetterClient adapts an ObjectGetter to the S3 client of the blob store.
+// Methods that are not part of ObjectGetter are called if the getter implements them and fail otherwise.
+type objectGetterClient struct {
+	ObjectGetter
+}
+
+// DeleteObject deletes the object if the getter implements ObjectDeleter.
+func (c objectGetterClient) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	deleter, ok := c.ObjectGetter.(ObjectDeleter)
+	if !ok {
+		return nil, fmt.Errorf("object getter does not implement DeleteObject: %w", errors.ErrUnsupported)
+	}
+	return deleter.DeleteObject(ctx, params, optFns...)
+}
+
+// HeadObject returns the object metadata if the getter implements HeadObject.
+func (c objectGetterClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	header, ok := c.ObjectGetter.(objectHeadClient)
+	if !ok {
+		return nil, fmt.Errorf("object getter does not implement HeadObject: %w", errors.ErrUnsupported)
+	}
+	return header.HeadObject(ctx, params, optFns...)
+}
+
+// ListObjectsV2 lists the objects if the getter implements ListObjectsV2.
+func (c objectGetterClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	lister, ok := c.ObjectGetter.(objectListClient)
+	if !ok {
+		return nil, fmt.Errorf("object getter does not implement ListObjectsV2: %w", errors.ErrUnsupported)
+	}
+	return lister.ListObjectsV2(ctx, params, optFns...)
+}
+
+// checkObjectGetter returns an error if the getter passed to New does not implement the S3 client methods
+// DeleteExpiredObjects uses. Services created with another constructor always pass.
+func (s *Service) checkObjectGetter() error {
+	if s.objGetter == nil {
+		return nil
+	}
+	var missing []string
+	if _, ok := s.objGetter.(ObjectDeleter); !ok {
+		missing = append(missing, "DeleteObject")
+	}
+	if _, ok := s.objGetter.(objectHeadClient); !ok {
+		missing = append(missing, "HeadObject")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("object getter does not implement %s: %w", strings.Join(missing, " and "), errors.ErrUnsupported)
+	}
+	return nil
+}
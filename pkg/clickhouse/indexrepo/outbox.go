@@ -0,0 +1,223 @@
+package indexrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+)
+
+// OutboxEntry is a write that StoreObject started but has not finished.
+type OutboxEntry struct {
+	// ID identifies the write. Writes of the same data to the same object have the same ID.
+	ID string `json:"id"`
+	// Bucket is the bucket the object is written to.
+	Bucket string `json:"bucket"`
+	// Key is the key of the object.
+	Key string `json:"key"`
+	// Checksum is the checksum of the object data, see chindexer.DataChecksum.
+	Checksum string `json:"checksum"`
+	// Header is the cloud event header the index is stored with.
+	Header cloudevent.CloudEventHeader `json:"header"`
+	// Created is the time the write started.
+	Created time.Time `json:"created"`
+}
+
+// Outbox durably records writes until both the object and its index are stored.
+type Outbox interface {
+	// Add records the entry. It must not return before the entry survives a crash.
+	// Adding an entry with the ID of an existing entry replaces it.
+	Add(ctx context.Context, entry OutboxEntry) error
+	// Remove removes the entry with the given ID. Removing a missing entry is not an error.
+	Remove(ctx context.Context, id string) error
+	// List returns all recorded entries ordered by ID.
+	List(ctx context.Context) ([]OutboxEntry, error)
+}
+
+// RecoveryResult is the outcome of recovering the outbox.
+type RecoveryResult struct {
+	// Completed is the number of writes whose index was stored by the recovery.
+	Completed int
+	// RolledBack is the number of writes that were dropped because their object was never stored
+	// or was overwritten by a later write.
+	RolledBack int
+}
+
+// WithOutbox makes StoreObject record every write in the outbox until both the object and its index are stored.
+// Writes interrupted by a crash or a failed insert are finished or rolled back by RecoverOutbox.
+func WithOutbox(outbox Outbox) Option {
+	return func(s *Service) {
+		s.outbox = outbox
+	}
+}
+
+// outboxID returns the ID of the write of data with the given checksum to the object.
+func outboxID(bucketName, key, checksum string) string {
+	return chindexer.DataChecksum([]byte(bucketName + "/" + key + "#" + checksum))[:32]
+}
+
+// RecoverOutbox finishes or rolls back the writes left in the outbox.
+// A write whose object is stored with the recorded checksum gets its index stored, any other write is dropped.
+// Storing an index again is safe because identical rows are merged by the table engine.
+// It should run on start up before the service stores objects. Entries that fail to recover are kept for the next run.
+func (s *Service) RecoverOutbox(ctx context.Context) (RecoveryResult, error) {
+	var result RecoveryResult
+	if s.outbox == nil {
+		return result, errors.New("no outbox configured")
+	}
+	entries, err := s.outbox.List(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list outbox entries: %w", err)
+	}
+	for i := range entries {
+		completed, err := s.recoverOutboxEntry(ctx, &entries[i])
+		if err != nil {
+			return result, fmt.Errorf("failed to recover write of object '%s': %w", entries[i].Key, err)
+		}
+		if err := s.outbox.Remove(ctx, entries[i].ID); err != nil {
+			return result, fmt.Errorf("failed to remove outbox entry: %w", err)
+		}
+		if completed {
+			result.Completed++
+		} else {
+			result.RolledBack++
+		}
+	}
+	return result, nil
+}
+
+// recoverOutboxEntry finishes the write of the entry if its object is stored.
+// It returns false when the write is rolled back instead.
+func (s *Service) recoverOutboxEntry(ctx context.Context, entry *OutboxEntry) (bool, error) {
+	info, err := s.store.Head(ctx, entry.Bucket, entry.Key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get object info: %w", err)
+	}
+	checksum := info.Metadata[ChecksumMetadataKey]
+	if checksum == "" {
+		data, _, err := s.readObject(ctx, ObjectInfo{Key: entry.Key}, entry.Bucket)
+		if err != nil {
+			return false, err
+		}
+		checksum = chindexer.DataChecksum(data)
+	}
+	if checksum != entry.Checksum {
+		// a later write replaced the object and is responsible for its own index
+		return false, nil
+	}
+	values := chindexer.CloudEventToSliceWithRef(&entry.Header, chindexer.ObjectRef{Key: entry.Key, Checksum: entry.Checksum})
+	if err := s.chConn.Exec(ctx, chindexer.InsertStmt, values...); err != nil {
+		return false, fmt.Errorf("failed to store index in ClickHouse: %w", err)
+	}
+	s.invalidateCachedObject(ctx, entry.Bucket, entry.Key)
+	return true, nil
+}
+
+// outboxEntrySuffix is the file name suffix of entries in a FileOutbox.
+const outboxEntrySuffix = ".json"
+
+// FileOutbox is an Outbox that keeps one file per entry in a local directory.
+type FileOutbox struct {
+	dir string
+}
+
+// NewFileOutbox creates an outbox in the given directory, creating the directory if needed.
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return &FileOutbox{dir: dir}, nil
+}
+
+// Add writes the entry to a temporary file, syncs it and renames it into place.
+func (o *FileOutbox) Add(_ context.Context, entry OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+	tmp, err := os.CreateTemp(o.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to create outbox entry: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint // the file is already renamed on success
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint
+		return fmt.Errorf("failed to sync outbox entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path(entry.ID)); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return o.syncDir()
+}
+
+// Remove deletes the file of the entry.
+func (o *FileOutbox) Remove(_ context.Context, id string) error {
+	if err := os.Remove(o.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove outbox entry: %w", err)
+	}
+	return o.syncDir()
+}
+
+// List reads all entry files. Temporary files of unfinished adds are ignored.
+func (o *FileOutbox) List(_ context.Context) ([]OutboxEntry, error) {
+	dirEntries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	var entries []OutboxEntry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, outboxEntrySuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(o.dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox entry: %w", err)
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal outbox entry '%s': %w", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b OutboxEntry) int { return strings.Compare(a.ID, b.ID) })
+	return entries, nil
+}
+
+// path returns the path of the file of the entry with the given ID.
+func (o *FileOutbox) path(id string) string {
+	return filepath.Join(o.dir, id+outboxEntrySuffix)
+}
+
+// syncDir syncs the outbox directory so renames and removals survive a crash.
+func (o *FileOutbox) syncDir() error {
+	dir, err := os.Open(o.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox directory: %w", err)
+	}
+	defer dir.Close() //nolint // we are not interested in the error here
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync outbox directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package indexrepo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+var errInjected = errors.New("injected fault")
+
+// faultStore wraps a blob store and fails puts while failPut is set.
+type faultStore struct {
+	blobstore.BlobStore
+	failPut bool
+}
+
+func (f *faultStore) Put(ctx context.Context, bucket, key string, data []byte, metadata map[string]string) error {
+	if f.failPut {
+		return errInjected
+	}
+	return f.BlobStore.Put(ctx, bucket, key, data, metadata)
+}
+
+// faultConn records the inserted rows and fails inserts while failExec is set.
+// Calling any other method panics.
+type faultConn struct {
+	driver.Conn
+	mu       sync.Mutex
+	failExec bool
+	rows     [][]any
+}
+
+func (f *faultConn) Exec(_ context.Context, _ string, args ...any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failExec {
+		return errInjected
+	}
+	f.rows = append(f.rows, args)
+	return nil
+}
+
+func TestStoreObjectOutbox(t *testing.T) {
+	ctx := context.Background()
+	header := cloudevent.CloudEventHeader{
+		ID:          "event-1",
+		Subject:     "did:nft:153:0x1:1",
+		Time:        time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		DataVersion: dataType,
+	}
+	key := nameindexer.CloudEventToIndexKey(&header)
+	content := []byte(`{"speed":42}`)
+
+	newService := func(t *testing.T) (*indexrepo.Service, *faultStore, *faultConn, *indexrepo.FileOutbox) {
+		t.Helper()
+		outbox, err := indexrepo.NewFileOutbox(t.TempDir())
+		require.NoError(t, err)
+		store := &faultStore{BlobStore: blobstore.NewMemory()}
+		conn := &faultConn{}
+		return indexrepo.NewWithBlobStore(conn, store, indexrepo.WithOutbox(outbox)), store, conn, outbox
+	}
+
+	t.Run("completed write leaves no entry", func(t *testing.T) {
+		indexService, _, conn, outbox := newService(t)
+		require.NoError(t, indexService.StoreObject(ctx, "bucket", &header, content))
+		require.Len(t, conn.rows, 1)
+		entries, err := outbox.List(ctx)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("failed insert is completed", func(t *testing.T) {
+		indexService, _, conn, outbox := newService(t)
+		conn.failExec = true
+		require.ErrorIs(t, indexService.StoreObject(ctx, "bucket", &header, content), errInjected)
+		entries, err := outbox.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, key, entries[0].Key)
+
+		// recovery keeps the entry while the index can not be stored
+		_, err = indexService.RecoverOutbox(ctx)
+		require.ErrorIs(t, err, errInjected)
+		entries, err = outbox.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		conn.failExec = false
+		result, err := indexService.RecoverOutbox(ctx)
+		require.NoError(t, err)
+		require.Equal(t, indexrepo.RecoveryResult{Completed: 1}, result)
+		require.Len(t, conn.rows, 1)
+		require.Equal(t, chindexer.CloudEventToSliceWithRef(&header, chindexer.ObjectRef{Key: key, Checksum: chindexer.DataChecksum(content)}), conn.rows[0])
+		entries, err = outbox.List(ctx)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("failed put is rolled back", func(t *testing.T) {
+		indexService, store, conn, outbox := newService(t)
+		store.failPut = true
+		require.ErrorIs(t, indexService.StoreObject(ctx, "bucket", &header, content), errInjected)
+		store.failPut = false
+
+		result, err := indexService.RecoverOutbox(ctx)
+		require.NoError(t, err)
+		require.Equal(t, indexrepo.RecoveryResult{RolledBack: 1}, result)
+		require.Empty(t, conn.rows)
+		entries, err := outbox.List(ctx)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("overwritten object is rolled back", func(t *testing.T) {
+		indexService, store, conn, _ := newService(t)
+		conn.failExec = true
+		require.Error(t, indexService.StoreObject(ctx, "bucket", &header, content))
+		conn.failExec = false
+		require.NoError(t, store.Put(ctx, "bucket", key, []byte(`{"speed":7}`), nil))
+
+		result, err := indexService.RecoverOutbox(ctx)
+		require.NoError(t, err)
+		require.Equal(t, indexrepo.RecoveryResult{RolledBack: 1}, result)
+		require.Empty(t, conn.rows)
+	})
+
+	t.Run("retried write shares the entry", func(t *testing.T) {
+		indexService, _, conn, outbox := newService(t)
+		conn.failExec = true
+		require.Error(t, indexService.StoreObject(ctx, "bucket", &header, content))
+		require.Error(t, indexService.StoreObject(ctx, "bucket", &header, content))
+		entries, err := outbox.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.True(t, header.Time.Equal(entries[0].Header.Time))
+	})
+}
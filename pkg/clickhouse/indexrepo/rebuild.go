@@ -0,0 +1,276 @@
+package indexrepo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultRebuildBatchSize is the default number of objects indexed per insert and checkpoint.
+const defaultRebuildBatchSize = 1000
+
+// maxArchivedDays is the number of subject days whose archived cloud events a rebuild keeps in memory.
+const maxArchivedDays = 64
+
+// RebuildOptions configures Rebuild.
+type RebuildOptions struct {
+	// Prefix limits the rebuild to objects whose key starts with it.
+	Prefix string
+	// StartAfter resumes the rebuild after this key, usually the last checkpoint.
+	StartAfter string
+	// Concurrency is the number of objects read at once. It defaults to the fetch concurrency of the service.
+	Concurrency int
+	// BatchSize is the number of objects indexed per insert and checkpoint. It defaults to 1000.
+	BatchSize int
+	// Checkpoint if set is called with the last key of every batch once its indexes are stored.
+	// Passing that key as StartAfter resumes the rebuild without skipping objects.
+	Checkpoint func(ctx context.Context, key string) error
+	// OnFailure if set is called for every object that can not be indexed and the rebuild continues.
+	// It is called before the batch of the object is checkpointed, so the key can be recorded before the
+	// checkpoint moves past it. If not set the first failure stops the rebuild.
+	OnFailure func(key string, err error)
+}
+
+// RebuildResult is the outcome of a rebuild.
+type RebuildResult struct {
+	// Objects is the number of objects indexed.
+	Objects int
+	// Indexes is the number of index rows stored.
+	Indexes int
+	// Replaced is the number of objects skipped because archives hold all of their cloud events.
+	Replaced int
+	// Failed is the number of objects that could not be indexed.
+	Failed int
+	// FailedKeys are the keys of the objects that could not be indexed in the order they were indexed.
+	// They are skipped by a rebuild that resumes from a checkpoint, so retry them with RebuildKeys.
+	FailedKeys []string
+	// LastKey is the last key of the last stored batch.
+	LastKey string
+}
+
+// Rebuild walks the objects in the bucket in key order and stores the index of every cloud event in them, see IndexObject.
+// Objects of a batch are read concurrently and their indexes are stored with a single insert before the batch is checkpointed,
+// so an interrupted rebuild resumes from the last checkpoint. Storing an index again is safe because identical rows are merged by the table engine.
+// Objects without an embedded cloud event header are indexed from their key, which has no ID and only second precision.
+// Their rows are not merged with rows of the same cloud event that are still in the table.
+// Cloud events that an archive of the same subject and day holds are only indexed from the archive,
+// so objects an archive replaced but that were not deleted yet do not take their cloud events back, see ArchiveSubjectDay.
+func (s *Service) Rebuild(ctx context.Context, bucketName string, opts RebuildOptions) (RebuildResult, error) {
+	opts = s.rebuildDefaults(opts)
+	var result RebuildResult
+	archived := archivedDays{}
+	keys := make([]string, 0, opts.BatchSize)
+	for info, err := range s.store.List(ctx, bucketName, opts.Prefix, opts.StartAfter) {
+		if err != nil {
+			return result, fmt.Errorf("failed to list objects: %w", err)
+		}
+		keys = append(keys, info.Key)
+		if len(keys) < opts.BatchSize {
+			continue
+		}
+		if err := s.rebuildBatch(ctx, bucketName, keys, &opts, archived, &result); err != nil {
+			return result, err
+		}
+		keys = keys[:0]
+	}
+	if len(keys) > 0 {
+		if err := s.rebuildBatch(ctx, bucketName, keys, &opts, archived, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RebuildKeys indexes the objects with the given keys like Rebuild, for example to retry the FailedKeys of a rebuild.
+// The keys are indexed in the given order. Prefix, StartAfter and Checkpoint are ignored.
+func (s *Service) RebuildKeys(ctx context.Context, bucketName string, keys []string, opts RebuildOptions) (RebuildResult, error) {
+	opts = s.rebuildDefaults(opts)
+	opts.Checkpoint = nil
+	var result RebuildResult
+	archived := archivedDays{}
+	for batch := range slices.Chunk(keys, opts.BatchSize) {
+		if err := s.rebuildBatch(ctx, bucketName, batch, &opts, archived, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// rebuildDefaults returns the options with the defaults of unset fields applied.
+func (s *Service) rebuildDefaults(opts RebuildOptions) RebuildOptions {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = s.fetchConcurrency
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultRebuildBatchSize
+	}
+	return opts
+}
+
+// rebuildBatch indexes the objects with the given keys, stores the indexes and checkpoints the last key.
+func (s *Service) rebuildBatch(ctx context.Context, bucketName string, keys []string, opts *RebuildOptions, archived archivedDays, result *RebuildResult) error {
+	indexes := make([][]cloudevent.CloudEvent[chindexer.ObjectRef], len(keys))
+	failures := make([]error, len(keys))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(opts.Concurrency)
+	for i, key := range keys {
+		group.Go(func() error {
+			objectIndexes, err := s.IndexObject(groupCtx, bucketName, key)
+			if err != nil {
+				if opts.OnFailure == nil || groupCtx.Err() != nil {
+					return fmt.Errorf("failed to index object '%s': %w", key, err)
+				}
+				failures[i] = err
+				return nil
+			}
+			indexes[i] = objectIndexes
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	var rows []cloudevent.CloudEvent[chindexer.ObjectRef]
+	var objects, replaced int
+	var failed []string
+	for i, key := range keys {
+		objectIndexes, err := indexes[i], failures[i]
+		if err == nil {
+			objectIndexes, err = s.withoutArchived(ctx, bucketName, key, objectIndexes, archived)
+			if err != nil && opts.OnFailure == nil {
+				return fmt.Errorf("failed to index object '%s': %w", key, err)
+			}
+		}
+		if err != nil {
+			opts.OnFailure(key, err)
+			failed = append(failed, key)
+			continue
+		}
+		if len(objectIndexes) == 0 && len(indexes[i]) > 0 {
+			replaced++
+			continue
+		}
+		rows = append(rows, objectIndexes...)
+		objects++
+	}
+	if len(rows) > 0 {
+		if err := s.InsertIndexes(ctx, rows); err != nil {
+			return err
+		}
+	}
+	lastKey := keys[len(keys)-1]
+	if opts.Checkpoint != nil {
+		if err := opts.Checkpoint(ctx, lastKey); err != nil {
+			return fmt.Errorf("failed to checkpoint rebuild: %w", err)
+		}
+	}
+	result.Objects += objects
+	result.Indexes += len(rows)
+	result.Replaced += replaced
+	result.Failed += len(failed)
+	result.FailedKeys = append(result.FailedKeys, failed...)
+	result.LastKey = lastKey
+	return nil
+}
+
+// archivedDays caches the cloud events in the archives of each subject and day by their key prefix during a rebuild.
+type archivedDays map[string]*archivedDay
+
+// archivedDay holds the cloud events in the archives of a subject and day.
+type archivedDay struct {
+	// keys are the index keys of the cloud events, which are the keys of the objects they were archived from.
+	keys map[string]struct{}
+	// events identify the cloud events in batch objects.
+	events map[archivedEvent]struct{}
+}
+
+// archivedEvent identifies an archived cloud event. The time has the millisecond precision of the archive.
+type archivedEvent struct {
+	subject, eventType, id, source, producer, dataVersion string
+	time                                                  int64
+}
+
+func newArchivedEvent(hdr *cloudevent.CloudEventHeader) archivedEvent {
+	return archivedEvent{
+		subject:     hdr.Subject,
+		eventType:   hdr.Type,
+		id:          hdr.ID,
+		source:      hdr.Source,
+		producer:    hdr.Producer,
+		dataVersion: hdr.DataVersion,
+		time:        hdr.Time.UnixMilli(),
+	}
+}
+
+// withoutArchived returns the indexes of the object with the given key without the cloud events that an archive holds.
+// Objects that are not batches are matched by their key the same way ReplacedBy matches them and are dropped as a whole.
+// Indexes of archives and of objects whose key is not a valid index key are returned unchanged.
+func (s *Service) withoutArchived(ctx context.Context, bucketName, key string, indexes []cloudevent.CloudEvent[chindexer.ObjectRef], archived archivedDays) ([]cloudevent.CloudEvent[chindexer.ObjectRef], error) {
+	index, err := nameindexer.DecodeIndex(key)
+	if err != nil || strings.HasPrefix(index.Optional, ArchiveKeySuffix) {
+		return indexes, nil
+	}
+	if !strings.HasPrefix(index.Optional, BatchKeySuffix) {
+		day, err := s.archivedDay(ctx, bucketName, key, archived)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := day.keys[key]; ok {
+			return nil, nil
+		}
+		return indexes, nil
+	}
+	kept := indexes[:0]
+	for _, row := range indexes {
+		day, err := s.archivedDay(ctx, bucketName, nameindexer.CloudEventToIndexKey(&row.CloudEventHeader), archived)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := day.events[newArchivedEvent(&row.CloudEventHeader)]; !ok {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+// archivedDay returns the cloud events in the archives of the subject and day of the index key.
+// Archives share the subject and date prefix of the keys of the cloud events they hold, so they are found by listing it.
+func (s *Service) archivedDay(ctx context.Context, bucketName, key string, archived archivedDays) (*archivedDay, error) {
+	if _, err := nameindexer.DecodeIndex(key); err != nil {
+		return &archivedDay{}, nil
+	}
+	prefix := key[:nameindexer.DIDLength+nameindexer.DateLength]
+	if day, ok := archived[prefix]; ok {
+		return day, nil
+	}
+	day := &archivedDay{keys: map[string]struct{}{}, events: map[archivedEvent]struct{}{}}
+	for info, err := range s.store.List(ctx, bucketName, prefix, "") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list archives: %w", err)
+		}
+		index, err := nameindexer.DecodeIndex(info.Key)
+		if err != nil || !strings.HasPrefix(index.Optional, ArchiveKeySuffix) {
+			continue
+		}
+		indexes, err := s.IndexObject(ctx, bucketName, info.Key)
+		if err != nil {
+			return nil, err
+		}
+		for i := range indexes {
+			day.keys[nameindexer.CloudEventToIndexKey(&indexes[i].CloudEventHeader)] = struct{}{}
+			day.events[newArchivedEvent(&indexes[i].CloudEventHeader)] = struct{}{}
+		}
+	}
+	if len(archived) >= maxArchivedDays {
+		clear(archived)
+	}
+	archived[prefix] = day
+	return day, nil
+}
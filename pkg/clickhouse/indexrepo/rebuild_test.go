@@ -0,0 +1,181 @@
+package indexrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// batchConn records the rows of sent batches and fails sends once failAfter batches were sent.
+// Calling any other method panics.
+type batchConn struct {
+	driver.Conn
+	mu        sync.Mutex
+	rows      [][]any
+	sent      int
+	failAfter int
+}
+
+func (b *batchConn) PrepareBatch(context.Context, string, ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &recordingBatch{conn: b}, nil
+}
+
+// recordingBatch collects appended rows until it is sent.
+type recordingBatch struct {
+	driver.Batch
+	conn *batchConn
+	rows [][]any
+}
+
+func (r *recordingBatch) Append(v ...any) error {
+	r.rows = append(r.rows, v)
+	return nil
+}
+
+func (r *recordingBatch) Abort() error { return nil }
+
+func (r *recordingBatch) Send() error {
+	r.conn.mu.Lock()
+	defer r.conn.mu.Unlock()
+	if r.conn.failAfter > 0 && r.conn.sent >= r.conn.failAfter {
+		return errInjected
+	}
+	r.conn.sent++
+	r.conn.rows = append(r.conn.rows, r.rows...)
+	return nil
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	var keys []string
+	for i := range 7 {
+		hdr := testHeader(i)
+		key := nameindexer.CloudEventToIndexKey(&hdr)
+		require.NoError(t, store.Put(ctx, "bucket", key, []byte(fmt.Sprintf(`{"speed":%d}`, i)), nil))
+		keys = append(keys, key)
+	}
+	require.NoError(t, store.Put(ctx, "bucket", "unknown", []byte(`{"speed":0}`), nil))
+
+	t.Run("failures are reported", func(t *testing.T) {
+		conn := &batchConn{}
+		indexService := indexrepo.NewWithBlobStore(conn, store)
+		var failed []string
+		var checkpoints []string
+		result, err := indexService.Rebuild(ctx, "bucket", indexrepo.RebuildOptions{
+			BatchSize:  3,
+			Checkpoint: func(_ context.Context, key string) error { checkpoints = append(checkpoints, key); return nil },
+			OnFailure:  func(key string, _ error) { failed = append(failed, key) },
+		})
+		require.NoError(t, err)
+		require.Equal(t, indexrepo.RebuildResult{Objects: 7, Indexes: 7, Failed: 1, FailedKeys: []string{"unknown"}, LastKey: "unknown"}, result)
+		require.Equal(t, []string{"unknown"}, failed)
+		require.Len(t, checkpoints, 3)
+		require.Len(t, conn.rows, 7)
+		for _, row := range conn.rows {
+			require.Len(t, row, len(chindexer.Columns))
+			require.Contains(t, keys, row[len(row)-1])
+		}
+	})
+
+	t.Run("first failure stops the rebuild", func(t *testing.T) {
+		indexService := indexrepo.NewWithBlobStore(&batchConn{}, store)
+		_, err := indexService.Rebuild(ctx, "bucket", indexrepo.RebuildOptions{})
+		require.Error(t, err)
+	})
+
+	t.Run("resumes from the checkpoint", func(t *testing.T) {
+		conn := &batchConn{failAfter: 1}
+		indexService := indexrepo.NewWithBlobStore(conn, store)
+		var checkpoint string
+		opts := indexrepo.RebuildOptions{
+			Prefix:     keys[0][:nameindexer.DIDLength],
+			BatchSize:  3,
+			Checkpoint: func(_ context.Context, key string) error { checkpoint = key; return nil },
+		}
+		_, err := indexService.Rebuild(ctx, "bucket", opts)
+		require.ErrorIs(t, err, errInjected)
+		require.Len(t, conn.rows, 3)
+
+		conn.failAfter = 0
+		opts.StartAfter = checkpoint
+		result, err := indexService.Rebuild(ctx, "bucket", opts)
+		require.NoError(t, err)
+		require.Equal(t, 4, result.Objects)
+		require.Len(t, conn.rows, 7)
+	})
+
+	t.Run("failed keys are retried", func(t *testing.T) {
+		conn := &batchConn{}
+		indexService := indexrepo.NewWithBlobStore(conn, store)
+		result, err := indexService.RebuildKeys(ctx, "bucket", []string{keys[2], "unknown", keys[0]}, indexrepo.RebuildOptions{
+			BatchSize:  2,
+			Checkpoint: func(context.Context, string) error { t.Fatal("retries must not checkpoint"); return nil },
+			OnFailure:  func(string, error) {},
+		})
+		require.NoError(t, err)
+		require.Equal(t, indexrepo.RebuildResult{Objects: 2, Indexes: 2, Failed: 1, FailedKeys: []string{"unknown"}, LastKey: keys[0]}, result)
+		require.Len(t, conn.rows, 2)
+		require.Equal(t, keys[2], conn.rows[0][len(conn.rows[0])-1])
+	})
+}
+
+func TestRebuildSkipsArchivedEvents(t *testing.T) {
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	var keys []string
+	for i := range 3 {
+		hdr := testHeader(i)
+		key := nameindexer.CloudEventToIndexKey(&hdr)
+		require.NoError(t, store.Put(ctx, "bucket", key, []byte(fmt.Sprintf(`{"speed":%d}`, i)), nil))
+		keys = append(keys, key)
+	}
+	batchEvents := []cloudevent.CloudEvent[json.RawMessage]{
+		{CloudEventHeader: testHeader(3), Data: json.RawMessage(`{"speed":3}`)},
+		{CloudEventHeader: testHeader(4), Data: json.RawMessage(`{"speed":4}`)},
+	}
+	batchEvents[0].ID = "batch-3"
+	batchEvents[1].ID = "batch-4"
+	batchKey, err := indexrepo.NewWithBlobStore(&batchConn{}, store).StoreCloudEventBatch(ctx, "bucket", batchEvents)
+	require.NoError(t, err)
+
+	// The archive holds the first two objects and the first cloud event of the batch, which were not deleted yet.
+	archived := []cloudevent.CloudEventHeader{testHeader(0), testHeader(1), batchEvents[0].CloudEventHeader}
+	archive, err := indexrepo.WriteEventArchive(archived)
+	require.NoError(t, err)
+	archiveKey := keys[0] + indexrepo.ArchiveKeySuffix + "0"
+	require.NoError(t, store.Put(ctx, "bucket", archiveKey, archive, nil))
+
+	conn := &batchConn{}
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+	result, err := indexService.Rebuild(ctx, "bucket", indexrepo.RebuildOptions{BatchSize: 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Objects)
+	require.Equal(t, 2, result.Replaced)
+	require.Equal(t, 5, result.Indexes)
+
+	rowKeys := map[string][]string{}
+	for _, row := range conn.rows {
+		key := row[len(row)-1].(string)
+		rowKeys[key] = append(rowKeys[key], row[3].(string))
+	}
+	require.Equal(t, map[string][]string{
+		archiveKey: {"event", "event", "batch-3"},
+		batchKey:   {"batch-4"},
+		keys[2]:    {""},
+	}, rowKeys)
+
+	result, err = indexService.RebuildKeys(ctx, "bucket", []string{keys[1], keys[2]}, indexrepo.RebuildOptions{})
+	require.NoError(t, err)
+	require.Equal(t, indexrepo.RebuildResult{Objects: 1, Indexes: 1, Replaced: 1, LastKey: keys[2]}, result)
+}
@@ -0,0 +1,281 @@
+package indexrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// ErrObjectReplaced is returned when repairing an object whose cloud event is indexed in another object.
+var ErrObjectReplaced = errors.New("object replaced")
+
+// FindingKind is the kind of disagreement between the bucket and the index.
+type FindingKind int
+
+const (
+	// FindingOrphanObject is an object without any index row.
+	FindingOrphanObject FindingKind = iota
+	// FindingDanglingIndex is an index key without an object.
+	FindingDanglingIndex
+	// FindingUndecodableKey is an object whose key is not a valid index key.
+	FindingUndecodableKey
+	// FindingReplacedObject is an object without any index row whose cloud event is indexed in another object,
+	// such as an archive. It is left over until it is deleted and must not be indexed again.
+	FindingReplacedObject
+)
+
+// String returns the name of the finding kind.
+func (k FindingKind) String() string {
+	switch k {
+	case FindingOrphanObject:
+		return "orphan"
+	case FindingDanglingIndex:
+		return "dangling"
+	case FindingUndecodableKey:
+		return "undecodable"
+	case FindingReplacedObject:
+		return "replaced"
+	default:
+		return fmt.Sprintf("FindingKind(%d)", int(k))
+	}
+}
+
+// Finding is a single disagreement between the bucket and the index.
+type Finding struct {
+	// Kind is the kind of disagreement.
+	Kind FindingKind
+	// Key is the object or index key.
+	Key string
+	// Err is the decode error of undecodable keys.
+	Err error
+	// ReplacedBy is the key of the object that holds the cloud event of a replaced object.
+	ReplacedBy string
+}
+
+// Reconcile compares the keys of the objects in the bucket with the index keys in ClickHouse
+// and returns an iterator over the disagreements in key order.
+// Both sides are streamed in key order and merged, so only the current key of each side is held in memory.
+// The index is not partitioned by bucket, so the prefix should select the keys written to this bucket.
+// An undecodable key is reported in addition to its orphan finding.
+// An object without index rows is reported as replaced instead of orphan when the index holds its cloud event
+// under another key, see ReplacedBy.
+func (s *Service) Reconcile(ctx context.Context, bucketName, prefix string) iter.Seq2[Finding, error] {
+	return func(yield func(Finding, error) bool) {
+		query, args := newQuery(
+			qm.Select("DISTINCT "+chindexer.IndexKeyColumn),
+			qm.From(chindexer.TableName),
+			qm.Where("startsWith("+chindexer.IndexKeyColumn+", ?)", prefix),
+			qm.OrderBy(chindexer.IndexKeyColumn),
+		)
+		rows, err := s.chConn.Query(ctx, query, args...)
+		if err != nil {
+			yield(Finding{}, fmt.Errorf("failed to get index keys: %w", err))
+			return
+		}
+		defer rows.Close() //nolint // we are not interested in the error here
+		nextIndexKey := func() (string, bool, error) {
+			if !rows.Next() {
+				if err := rows.Err(); err != nil {
+					return "", false, fmt.Errorf("failed to iterate over index keys: %w", err)
+				}
+				return "", false, nil
+			}
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return "", false, fmt.Errorf("failed to scan index key: %w", err)
+			}
+			return key, true, nil
+		}
+
+		nextObject, stop := iter.Pull2(s.store.List(ctx, bucketName, prefix, ""))
+		defer stop()
+
+		object, objErr, objOK := nextObject()
+		indexKey, idxOK, idxErr := nextIndexKey()
+		for objOK || idxOK {
+			if objErr != nil {
+				yield(Finding{}, fmt.Errorf("failed to list objects: %w", objErr))
+				return
+			}
+			if idxErr != nil {
+				yield(Finding{}, idxErr)
+				return
+			}
+			advanceObject := objOK && (!idxOK || object.Key <= indexKey)
+			advanceIndex := idxOK && (!objOK || indexKey <= object.Key)
+			if advanceObject {
+				_, decodeErr := nameindexer.DecodeIndex(object.Key)
+				if decodeErr != nil {
+					if !yield(Finding{Kind: FindingUndecodableKey, Key: object.Key, Err: decodeErr}, nil) {
+						return
+					}
+				}
+				if !advanceIndex {
+					finding := Finding{Kind: FindingOrphanObject, Key: object.Key}
+					if decodeErr == nil {
+						replacedBy, err := s.ReplacedBy(ctx, object.Key)
+						if err != nil {
+							yield(Finding{}, err)
+							return
+						}
+						if replacedBy != "" {
+							finding = Finding{Kind: FindingReplacedObject, Key: object.Key, ReplacedBy: replacedBy}
+						}
+					}
+					if !yield(finding, nil) {
+						return
+					}
+				}
+			} else if !yield(Finding{Kind: FindingDanglingIndex, Key: indexKey}, nil) {
+				return
+			}
+			if advanceObject {
+				object, objErr, objOK = nextObject()
+			}
+			if advanceIndex {
+				indexKey, idxOK, idxErr = nextIndexKey()
+			}
+		}
+		if idxErr != nil {
+			yield(Finding{}, idxErr)
+		}
+	}
+}
+
+// IndexObject reads the object and returns the index of every cloud event in it.
+// Headers are taken from the cloud events embedded in the object and fall back to decoding the key.
+// Batch and archive objects, recognized by the suffix of their key, return one index per cloud event.
+func (s *Service) IndexObject(ctx context.Context, bucketName, key string) ([]cloudevent.CloudEvent[chindexer.ObjectRef], error) {
+	data, _, err := s.readObject(ctx, ObjectInfo{Key: key}, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var optional string
+	if index, err := nameindexer.DecodeIndex(key); err == nil {
+		optional = index.Optional
+	}
+	var indexes []cloudevent.CloudEvent[chindexer.ObjectRef]
+	switch {
+	case strings.HasPrefix(optional, ArchiveKeySuffix):
+		indexes, err = archiveIndexes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index archive '%s': %w", key, err)
+		}
+	case strings.HasPrefix(optional, BatchKeySuffix):
+		indexes, err = batchIndexes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index batch '%s': %w", key, err)
+		}
+	default:
+		hdr, err := objectHeader(key, data)
+		if err != nil {
+			return nil, err
+		}
+		indexes = []cloudevent.CloudEvent[chindexer.ObjectRef]{{CloudEventHeader: *hdr, Data: chindexer.ObjectRef{Checksum: chindexer.DataChecksum(data)}}}
+	}
+	for i := range indexes {
+		indexes[i].Data.Key = key
+	}
+	return indexes, nil
+}
+
+// ReplacedBy returns the key of another object whose index rows hold the cloud event of the object with the given key,
+// or an empty string if there is none. Objects are replaced when their cloud events are archived.
+// The cloud event is looked up by the subject and second of the key, so keys that are not valid index keys are never replaced.
+func (s *Service) ReplacedBy(ctx context.Context, key string) (string, error) {
+	hdr, err := nameindexer.IndexKeyToCloudEventHeader(key)
+	if err != nil {
+		return "", nil
+	}
+	start := hdr.Time.Truncate(time.Second)
+	opts := &SearchOptions{
+		Subject:        &hdr.Subject,
+		After:          start,
+		AfterInclusive: true,
+		Before:         start.Add(time.Second),
+	}
+	for index, err := range s.IterIndexes(ctx, 0, opts) {
+		if err != nil {
+			return "", err
+		}
+		if index.Data.Key != key && nameindexer.CloudEventToIndexKey(&index.CloudEventHeader) == key {
+			return index.Data.Key, nil
+		}
+	}
+	return "", nil
+}
+
+// RepairObject stores the indexes of the object, see IndexObject.
+// Storing the index of an object that is already indexed is safe because identical rows are merged by the table engine.
+// Objects whose cloud event is indexed in another object are not indexed again and return ErrObjectReplaced,
+// because their rows would replace the rows of the other object.
+func (s *Service) RepairObject(ctx context.Context, bucketName, key string) (int, error) {
+	replacedBy, err := s.ReplacedBy(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if replacedBy != "" {
+		return 0, fmt.Errorf("%w: '%s' is indexed in '%s'", ErrObjectReplaced, key, replacedBy)
+	}
+	indexes, err := s.IndexObject(ctx, bucketName, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.InsertIndexes(ctx, indexes); err != nil {
+		return 0, err
+	}
+	return len(indexes), nil
+}
+
+// objectHeader returns the header of the cloud event embedded in the data, the same way toCloudEvent detects it,
+// or the header decoded from the key.
+func objectHeader(key string, data []byte) (*cloudevent.CloudEventHeader, error) {
+	var event cloudevent.CloudEvent[json.RawMessage]
+	if err := json.Unmarshal(data, &event); err == nil && !event.CloudEventHeader.Equals(cloudevent.CloudEventHeader{}) {
+		return &event.CloudEventHeader, nil
+	}
+	hdr, err := nameindexer.IndexKeyToCloudEventHeader(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cloud event header of object '%s': %w", key, err)
+	}
+	return hdr, nil
+}
+
+// batchIndexes returns the index of every cloud event in an NDJSON batch. The refs have no key.
+func batchIndexes(batch []byte) ([]cloudevent.CloudEvent[chindexer.ObjectRef], error) {
+	var indexes []cloudevent.CloudEvent[chindexer.ObjectRef]
+	var offset int
+	for len(batch) > offset {
+		end := bytes.IndexByte(batch[offset:], '\n')
+		if end < 0 {
+			end = len(batch) - offset
+		}
+		line := batch[offset : offset+end]
+		if len(line) > 0 {
+			var event cloudevent.CloudEvent[json.RawMessage]
+			if err := json.Unmarshal(line, &event); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal cloud event at offset %d: %w", offset, err)
+			}
+			indexes = append(indexes, cloudevent.CloudEvent[chindexer.ObjectRef]{
+				CloudEventHeader: event.CloudEventHeader,
+				Data: chindexer.ObjectRef{
+					Offset:   uint64(offset),
+					Length:   uint64(len(line)),
+					Checksum: chindexer.DataChecksum(line),
+				},
+			})
+		}
+		offset += end + 1
+	}
+	return indexes, nil
+}
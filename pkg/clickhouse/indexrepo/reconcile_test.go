@@ -0,0 +1,194 @@
+package indexrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// keyConn answers queries for distinct index keys with the given index keys
+// and every other query with the given indexes, without filtering them.
+// Calling any other method panics.
+type keyConn struct {
+	driver.Conn
+	keys    []string
+	indexes []cloudevent.CloudEvent[indexrepo.ObjectInfo]
+}
+
+func (k *keyConn) Query(_ context.Context, query string, _ ...any) (driver.Rows, error) {
+	if strings.Contains(query, "DISTINCT") {
+		return &keyRows{keys: k.keys, pos: -1}, nil
+	}
+	return &indexRows{indexes: k.indexes, pos: -1}, nil
+}
+
+// keyRows returns a single string column per row.
+type keyRows struct {
+	driver.Rows
+	keys []string
+	pos  int
+}
+
+func (k *keyRows) Next() bool {
+	k.pos++
+	return k.pos < len(k.keys)
+}
+
+func (k *keyRows) Scan(dest ...any) error {
+	*dest[0].(*string) = k.keys[k.pos]
+	return nil
+}
+
+func (k *keyRows) Err() error   { return nil }
+func (k *keyRows) Close() error { return nil }
+
+// indexRows returns the columns of a cloud event index per row.
+type indexRows struct {
+	driver.Rows
+	indexes []cloudevent.CloudEvent[indexrepo.ObjectInfo]
+	pos     int
+}
+
+func (i *indexRows) Next() bool {
+	i.pos++
+	return i.pos < len(i.indexes)
+}
+
+func (i *indexRows) Scan(dest ...any) error {
+	index := i.indexes[i.pos]
+	*dest[0].(*string) = index.Subject
+	*dest[1].(*time.Time) = index.Time
+	*dest[2].(*string) = index.Type
+	*dest[3].(*string) = index.ID
+	*dest[4].(*string) = index.Source
+	*dest[5].(*string) = index.Producer
+	*dest[6].(*string) = index.DataContentType
+	*dest[7].(*string) = index.DataVersion
+	*dest[9].(*uint64) = index.Data.Offset
+	*dest[10].(*uint64) = index.Data.Length
+	*dest[11].(*string) = index.Data.Format
+	*dest[12].(*uint32) = index.Data.RowGroup
+	*dest[13].(*string) = index.Data.Checksum
+	*dest[14].(*string) = index.Data.Key
+	return nil
+}
+
+func (i *indexRows) Err() error   { return nil }
+func (i *indexRows) Close() error { return nil }
+
+func testHeader(i int) cloudevent.CloudEventHeader {
+	return cloudevent.CloudEventHeader{
+		ID:          "event",
+		Subject:     "did:nft:153:0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF:1",
+		Time:        time.Date(2024, 6, 1, 12, 0, i, 0, time.UTC),
+		Type:        cloudevent.TypeStatus,
+		Source:      "0x6C7cFb99AcfEFbA12DeD34387c11697061C196d0",
+		DataVersion: dataType,
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	for i := range 5 {
+		hdr := testHeader(i)
+		keys = append(keys, nameindexer.CloudEventToIndexKey(&hdr))
+	}
+	// keys sort by subject and then newest first
+	store := blobstore.NewMemory()
+	for _, key := range []string{keys[0], keys[1], keys[3], keys[4], "not-an-index-key"} {
+		require.NoError(t, store.Put(ctx, "bucket", key, []byte(`{}`), nil))
+	}
+	// the cloud event of keys[4] was archived, so its object is left over without index rows
+	archived := cloudevent.CloudEvent[indexrepo.ObjectInfo]{CloudEventHeader: testHeader(4), Data: indexrepo.ObjectInfo{Key: "archive", Format: chindexer.DataFormatParquet}}
+	conn := &keyConn{keys: []string{keys[1], keys[2], keys[3]}, indexes: []cloudevent.CloudEvent[indexrepo.ObjectInfo]{archived}}
+	indexService := indexrepo.NewWithBlobStore(conn, store)
+
+	found := map[indexrepo.FindingKind][]string{}
+	for finding, err := range indexService.Reconcile(ctx, "bucket", "") {
+		require.NoError(t, err)
+		found[finding.Kind] = append(found[finding.Kind], finding.Key)
+		if finding.Kind == indexrepo.FindingUndecodableKey {
+			require.Error(t, finding.Err)
+		}
+	}
+	require.ElementsMatch(t, []string{keys[0], "not-an-index-key"}, found[indexrepo.FindingOrphanObject])
+	require.Equal(t, []string{keys[2]}, found[indexrepo.FindingDanglingIndex])
+	require.Equal(t, []string{"not-an-index-key"}, found[indexrepo.FindingUndecodableKey])
+	require.Equal(t, []string{keys[4]}, found[indexrepo.FindingReplacedObject])
+
+	replacedBy, err := indexService.ReplacedBy(ctx, keys[4])
+	require.NoError(t, err)
+	require.Equal(t, "archive", replacedBy)
+	_, err = indexService.RepairObject(ctx, "bucket", keys[4])
+	require.ErrorIs(t, err, indexrepo.ErrObjectReplaced)
+}
+
+func TestIndexObject(t *testing.T) {
+	ctx := context.Background()
+	store := blobstore.NewMemory()
+	indexService := indexrepo.NewWithBlobStore(nil, store)
+
+	// a plain payload falls back to the key
+	hdr := testHeader(0)
+	key := nameindexer.CloudEventToIndexKey(&hdr)
+	require.NoError(t, store.Put(ctx, "bucket", key, []byte(`{"speed":1}`), nil))
+	indexes, err := indexService.IndexObject(ctx, "bucket", key)
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	require.Equal(t, hdr.Subject, indexes[0].Subject)
+	require.Equal(t, hdr.Source, indexes[0].Source)
+	require.True(t, hdr.Time.Equal(indexes[0].Time))
+	require.Equal(t, chindexer.ObjectRef{Key: key, Checksum: chindexer.DataChecksum([]byte(`{"speed":1}`))}, indexes[0].Data)
+
+	// an embedded cloud event header wins over the key
+	embedded := cloudevent.CloudEvent[json.RawMessage]{CloudEventHeader: testHeader(1), Data: json.RawMessage(`{"speed":2}`)}
+	embedded.ID = "embedded"
+	embedded.Extras = map[string]any{"vin": "123"}
+	data, err := json.Marshal(embedded)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "bucket", "custom-key", data, nil))
+	indexes, err = indexService.IndexObject(ctx, "bucket", "custom-key")
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	require.Equal(t, "embedded", indexes[0].ID)
+	require.Equal(t, "123", indexes[0].Extras["vin"])
+
+	// batches are indexed per line
+	batch := []byte("{\"id\":\"a\",\"subject\":\"s\"}\n{\"id\":\"b\",\"subject\":\"s\"}\n")
+	batchKey := key + indexrepo.BatchKeySuffix + "0011"
+	require.NoError(t, store.Put(ctx, "bucket", batchKey, batch, nil))
+	indexes, err = indexService.IndexObject(ctx, "bucket", batchKey)
+	require.NoError(t, err)
+	require.Len(t, indexes, 2)
+	require.Equal(t, "b", indexes[1].ID)
+	require.Equal(t, chindexer.ObjectRef{Key: batchKey, Offset: 25, Length: 24, Checksum: chindexer.DataChecksum(batch[25:49])}, indexes[1].Data)
+
+	// archives are indexed per row
+	archive, refs, err := indexrepo.WriteArchive([][]byte{[]byte("1"), []byte("2"), []byte("3")}, 2)
+	require.NoError(t, err)
+	archiveKey := key + indexrepo.ArchiveKeySuffix + "0011"
+	require.NoError(t, store.Put(ctx, "bucket", archiveKey, archive, nil))
+	indexes, err = indexService.IndexObject(ctx, "bucket", archiveKey)
+	require.NoError(t, err)
+	require.Len(t, indexes, 3)
+	for i := range indexes {
+		refs[i].Key = archiveKey
+		require.Equal(t, refs[i], indexes[i].Data)
+	}
+
+	// an undecodable key without an embedded header can not be indexed
+	require.NoError(t, store.Put(ctx, "bucket", "unknown", []byte(`{"speed":3}`), nil))
+	_, err = indexService.IndexObject(ctx, "bucket", "unknown")
+	require.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package indexrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// DeleteExpiredObjects deletes the objects of cloud events that are older than the retention of their type.
+// The index rows are left for the table TTL to remove, which is applied with a grace period by
+// chindexer.RetentionTTLStmt. Run this job more often than that grace period so objects are removed before their rows expire.
+// Objects that hold several cloud events, such as batches and archives, are only deleted once every cloud event in them expired.
+// It returns the number of deleted objects. Objects that were already deleted by an earlier run are not counted.
+// A service created with New fails with errors.ErrUnsupported unless its getter can also delete and head objects.
+func (s *Service) DeleteExpiredObjects(ctx context.Context, bucketName string, policies []chindexer.RetentionPolicy, now time.Time) (int, error) {
+	if len(policies) == 0 {
+		return 0, nil
+	}
+	if err := s.checkObjectGetter(); err != nil {
+		return 0, err
+	}
+	expired := make([]string, len(policies))
+	var args []any
+	for i, policy := range policies {
+		if err := policy.Validate(); err != nil {
+			return 0, err
+		}
+		expired[i] = "(" + chindexer.TypeColumn + " = ? AND " + chindexer.TimestampColumn + " < ?)"
+		args = append(args, policy.EventType, now.Add(-policy.Retention))
+	}
+	expiredExpr := strings.Join(expired, " OR ")
+	query, queryArgs := newQuery(
+		qm.Select(
+			chindexer.IndexKeyColumn,
+			"groupArray("+chindexer.DataFormatColumn+")",
+			"groupArray("+chindexer.DataRowGroupColumn+")",
+			"groupArray("+chindexer.DataOffsetColumn+")",
+			"groupArray("+chindexer.DataLengthColumn+")",
+		),
+		qm.From(chindexer.TableName),
+		qm.Where(chindexer.IndexKeyColumn+" IN (SELECT "+chindexer.IndexKeyColumn+" FROM "+chindexer.TableName+" WHERE "+expiredExpr+")", args...),
+		qm.GroupBy(chindexer.IndexKeyColumn),
+		// an object is kept while any of its rows is retained
+		qm.Having("min("+expiredExpr+") = 1", args...),
+	)
+	rows, err := s.chConn.Query(ctx, query, queryArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired index keys: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+
+	var deleted int
+	for rows.Next() {
+		var key string
+		var formats []string
+		var rowGroups []uint32
+		var offsets, lengths []uint64
+		if err := rows.Scan(&key, &formats, &rowGroups, &offsets, &lengths); err != nil {
+			return deleted, fmt.Errorf("failed to scan expired index key: %w", err)
+		}
+		// rows stay until the end of the grace period, so later runs select keys that are already deleted
+		if _, err := s.store.Head(ctx, bucketName, key); err != nil {
+			if errors.Is(err, blobstore.ErrNotFound) {
+				continue
+			}
+			return deleted, err
+		}
+		err := s.store.Delete(ctx, bucketName, key)
+		refs := make([]ObjectInfo, len(formats))
+		for i := range refs {
+			refs[i] = ObjectInfo{Key: key, Format: formats[i], RowGroup: rowGroups[i], Offset: offsets[i], Length: lengths[i]}
+		}
+		s.invalidateCachedRefs(ctx, bucketName, key, refs)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	if err := rows.Err(); err != nil {
+		return deleted, fmt.Errorf("failed to iterate over expired index keys: %w", err)
+	}
+	return deleted, nil
+}
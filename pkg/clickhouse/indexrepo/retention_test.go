@@ -0,0 +1,122 @@
+package indexrepo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/blobstore"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeleteExpiredObjects tests that only objects past their retention are deleted.
+func TestDeleteExpiredObjects(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	expiredStatus := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-100 * 24 * time.Hour),
+		Type:        cloudevent.TypeStatus,
+		DataVersion: dataType,
+	}
+	expiredKey := insertTestData(t, ctx, conn, expiredStatus)
+	recentStatus := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-1 * time.Hour),
+		Type:        cloudevent.TypeStatus,
+		DataVersion: dataType,
+	}
+	recentKey := insertTestData(t, ctx, conn, recentStatus)
+	oldCredential := &cloudevent.CloudEventHeader{
+		Subject:     subject,
+		Time:        now.Add(-100 * 24 * time.Hour),
+		Type:        cloudevent.TypeVerifableCredential,
+		DataVersion: dataType,
+	}
+	credentialKey := insertTestData(t, ctx, conn, oldCredential)
+
+	// batches are deleted once all of their cloud events expired
+	insertBatchRow := func(key string, hdr *cloudevent.CloudEventHeader, offset uint64) {
+		row := *hdr
+		row.ID = fmt.Sprintf("%s-%d", key, offset)
+		values := chindexer.CloudEventToSliceWithRef(&row, chindexer.ObjectRef{Key: key, Offset: offset, Length: 2})
+		require.NoError(t, conn.Exec(ctx, chindexer.InsertStmt, values...))
+	}
+	expiredBatchKey := expiredKey + indexrepo.BatchKeySuffix + "expired"
+	insertBatchRow(expiredBatchKey, expiredStatus, 0)
+	insertBatchRow(expiredBatchKey, expiredStatus, 3)
+	mixedBatchKey := expiredKey + indexrepo.BatchKeySuffix + "mixed"
+	insertBatchRow(mixedBatchKey, expiredStatus, 0)
+	insertBatchRow(mixedBatchKey, oldCredential, 3)
+	// archives are deleted once all of their rows expired
+	insertArchiveRow := func(key string, hdr *cloudevent.CloudEventHeader, row uint64) {
+		archiveRow := *hdr
+		archiveRow.ID = fmt.Sprintf("%s-%d", key, row)
+		values := chindexer.CloudEventToSliceWithRef(&archiveRow, chindexer.ObjectRef{Key: key, Offset: row, Format: chindexer.DataFormatParquet})
+		require.NoError(t, conn.Exec(ctx, chindexer.InsertStmt, values...))
+	}
+	expiredArchiveKey := expiredKey + indexrepo.ArchiveKeySuffix + "expired"
+	insertArchiveRow(expiredArchiveKey, expiredStatus, 0)
+	insertArchiveRow(expiredArchiveKey, expiredStatus, 1)
+	mixedArchiveKey := expiredKey + indexrepo.ArchiveKeySuffix + "mixed"
+	insertArchiveRow(mixedArchiveKey, expiredStatus, 0)
+	insertArchiveRow(mixedArchiveKey, recentStatus, 1)
+	archive, _, err := indexrepo.WriteArchive([][]byte{[]byte("{}"), []byte("{}")}, 2)
+	require.NoError(t, err)
+
+	store := blobstore.NewMemory()
+	for _, key := range []string{expiredKey, recentKey, credentialKey} {
+		require.NoError(t, store.Put(ctx, "test-bucket", key, []byte(`{}`), nil))
+	}
+	for _, key := range []string{expiredBatchKey, mixedBatchKey} {
+		require.NoError(t, store.Put(ctx, "test-bucket", key, []byte("{}\n{}\n"), nil))
+	}
+	for _, key := range []string{expiredArchiveKey, mixedArchiveKey} {
+		require.NoError(t, store.Put(ctx, "test-bucket", key, archive, nil))
+	}
+
+	indexService := indexrepo.NewWithBlobStore(conn, store, indexrepo.WithObjectCache(indexrepo.NewLRUCache(1<<20), false))
+	expiredRange := indexrepo.ObjectInfo{Key: expiredBatchKey, Offset: 3, Length: 2}
+	_, err = indexService.GetObjectFromRef(ctx, expiredRange, "test-bucket")
+	require.NoError(t, err)
+	expiredArchiveRow := indexrepo.ObjectInfo{Key: expiredArchiveKey, Offset: 1, Format: chindexer.DataFormatParquet}
+	_, err = indexService.GetObjectFromRef(ctx, expiredArchiveRow, "test-bucket")
+	require.NoError(t, err)
+
+	policies := []chindexer.RetentionPolicy{{EventType: cloudevent.TypeStatus, Retention: 90 * 24 * time.Hour}}
+	deleted, err := indexService.DeleteExpiredObjects(ctx, "test-bucket", policies, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+	for _, key := range []string{expiredKey, expiredBatchKey, expiredArchiveKey} {
+		_, err = store.Head(ctx, "test-bucket", key)
+		require.ErrorIs(t, err, blobstore.ErrNotFound)
+	}
+	for _, key := range []string{recentKey, credentialKey, mixedBatchKey, mixedArchiveKey} {
+		_, err = store.Head(ctx, "test-bucket", key)
+		require.NoError(t, err)
+	}
+	// cached ranges of deleted objects are dropped too
+	_, err = indexService.GetObjectFromRef(ctx, expiredRange, "test-bucket")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	_, err = indexService.GetObjectFromRef(ctx, expiredArchiveRow, "test-bucket")
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	// the rows of deleted objects stay until the end of the grace period and are not counted again
+	deleted, err = indexService.DeleteExpiredObjects(ctx, "test-bucket", policies, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+}
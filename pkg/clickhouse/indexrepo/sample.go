@@ -0,0 +1,143 @@
+package indexrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	chindexer "github.com/DIMO-Network/nameindexer/pkg/clickhouse"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// SampleMode selects which index is kept from each sample bucket.
+type SampleMode int
+
+const (
+	// SampleFirst keeps the earliest index in each bucket.
+	SampleFirst SampleMode = iota
+	// SampleLast keeps the latest index in each bucket.
+	SampleLast
+	// SampleCenter keeps the index closest to the middle of each bucket.
+	SampleCenter
+)
+
+// SampleOptions controls how indexes are downsampled.
+type SampleOptions struct {
+	// Interval is the size of each bucket. Buckets are aligned to the Unix epoch and must be whole seconds.
+	Interval time.Duration
+	// Mode selects which index is kept from each bucket.
+	Mode SampleMode
+}
+
+// ListSampledIndexes returns at most one index per subject and time bucket for the indexes that match the given options.
+// The sampled indexes are ordered like ListIndexes and the limit applies after sampling.
+// Cursors are not supported because a page boundary would split a bucket.
+func (s *Service) ListSampledIndexes(ctx context.Context, limit int, opts *SearchOptions, sample SampleOptions) ([]cloudevent.CloudEvent[ObjectInfo], error) {
+	opts, err := s.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := buildSampledIndexesQuery(limit, opts, sample)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.chConn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sampled cloud events: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+
+	var cloudEvents []cloudevent.CloudEvent[ObjectInfo]
+	for rows.Next() {
+		event, err := scanIndex(rows)
+		if err != nil {
+			return nil, err
+		}
+		cloudEvents = append(cloudEvents, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over sampled cloud events: %w", err)
+	}
+	if len(cloudEvents) == 0 {
+		return nil, fmt.Errorf("no cloud events found %w", sql.ErrNoRows)
+	}
+	return cloudEvents, nil
+}
+
+// ListSampledCloudEvents returns the full cloud events for the indexes returned by ListSampledIndexes.
+// Only the sampled objects are fetched.
+func (s *Service) ListSampledCloudEvents(ctx context.Context, bucketName string, limit int, opts *SearchOptions, sample SampleOptions) ([]cloudevent.CloudEvent[json.RawMessage], error) {
+	indexes, err := s.ListSampledIndexes(ctx, limit, opts, sample)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListCloudEventsFromIndexes(ctx, indexes, bucketName)
+}
+
+// buildSampledIndexesQuery builds the query used by ListSampledIndexes.
+// The inner query keeps one index per subject and bucket and the outer query orders and limits the result.
+func buildSampledIndexesQuery(limit int, opts *SearchOptions, sample SampleOptions) (string, []any, error) {
+	if sample.Interval < time.Second || sample.Interval%time.Second != 0 {
+		return "", nil, fmt.Errorf("sample interval must be a positive number of whole seconds, got %s", sample.Interval)
+	}
+	if opts != nil && opts.Cursor != "" {
+		return "", nil, errors.New("cursors are not supported when sampling")
+	}
+	rank, err := sampleRank(sample)
+	if err != nil {
+		return "", nil, err
+	}
+	bucket := intervalExpr(sample.Interval)
+	innerMods := []qm.QueryMod{
+		qm.Select(indexColumns...),
+		qm.From(fromTable(opts)),
+		// sqlboiler has no LIMIT BY clause, it is placed after the ORDER BY which is where ClickHouse expects it.
+		qm.OrderBy(chindexer.SubjectColumn + ", " + bucket + ", " + rank +
+			" LIMIT 1 BY " + chindexer.SubjectColumn + ", " + bucket),
+	}
+	optsMods, err := opts.QueryMods()
+	if err != nil {
+		return "", nil, err
+	}
+	innerMods = append(innerMods, optsMods...)
+	innerQuery, args := newQuery(innerMods...)
+
+	order := " DESC"
+	if opts != nil && opts.TimestampAsc {
+		order = " ASC"
+	}
+	mods := []qm.QueryMod{
+		qm.Select(indexColumns...),
+		qm.From("(" + strings.TrimSuffix(innerQuery, ";") + ")"),
+		qm.OrderBy(chindexer.TimestampColumn + order + ", " + chindexer.IDColumn + order + ", " + chindexer.IndexKeyColumn + order),
+	}
+	if limit > 0 {
+		mods = append(mods, qm.Limit(limit))
+	}
+	query, _ := newQuery(mods...)
+	return query, args, nil
+}
+
+// sampleRank returns the order by expression that puts the index to keep first in each bucket.
+func sampleRank(sample SampleOptions) (string, error) {
+	asc := chindexer.TimestampColumn + " ASC, " + chindexer.IDColumn + " ASC, " + chindexer.IndexKeyColumn + " ASC"
+	switch sample.Mode {
+	case SampleFirst:
+		return asc, nil
+	case SampleLast:
+		return chindexer.TimestampColumn + " DESC, " + chindexer.IDColumn + " DESC, " + chindexer.IndexKeyColumn + " DESC", nil
+	case SampleCenter:
+		// buckets are aligned to the epoch so the offset into the bucket is the time modulo the interval.
+		intervalMilli := sample.Interval.Milliseconds()
+		offset := "toUnixTimestamp64Milli(" + chindexer.TimestampColumn + ") % " + strconv.FormatInt(intervalMilli, 10)
+		return "abs(" + offset + " - " + strconv.FormatInt(intervalMilli/2, 10) + ") ASC, " + asc, nil
+	default:
+		return "", fmt.Errorf("unknown sample mode %d", sample.Mode)
+	}
+}
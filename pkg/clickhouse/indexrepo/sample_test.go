@@ -0,0 +1,73 @@
+package indexrepo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/cloudevent"
+	"github.com/DIMO-Network/nameindexer/pkg/clickhouse/indexrepo"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListSampledIndexes tests downsampling indexes to one per subject and time bucket.
+func TestListSampledIndexes(t *testing.T) {
+	chContainer := setupClickHouseContainer(t)
+
+	conn, err := chContainer.GetClickHouseAsConn()
+	require.NoError(t, err)
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	subject := cloudevent.NFTDID{
+		ChainID:         153,
+		ContractAddress: randAddress(),
+		TokenID:         123456,
+	}.String()
+
+	// two ten minute buckets with an event at minute 1, 4 and 8 of each
+	var times []time.Time
+	for _, bucketStart := range []time.Time{start, start.Add(10 * time.Minute)} {
+		for _, minute := range []time.Duration{1, 4, 8} {
+			eventTime := bucketStart.Add(minute * time.Minute)
+			times = append(times, eventTime)
+			_ = insertTestData(t, ctx, conn, &cloudevent.CloudEventHeader{Subject: subject, Time: eventTime, DataVersion: dataType})
+		}
+	}
+
+	indexService := indexrepo.New(conn, nil)
+	opts := &indexrepo.SearchOptions{Subject: &subject, TimestampAsc: true}
+	tests := []struct {
+		name     string
+		mode     indexrepo.SampleMode
+		expected []time.Time
+	}{
+		{name: "first", mode: indexrepo.SampleFirst, expected: []time.Time{times[0], times[3]}},
+		{name: "last", mode: indexrepo.SampleLast, expected: []time.Time{times[2], times[5]}},
+		{name: "center", mode: indexrepo.SampleCenter, expected: []time.Time{times[1], times[4]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes, err := indexService.ListSampledIndexes(ctx, 10, opts, indexrepo.SampleOptions{Interval: 10 * time.Minute, Mode: tt.mode})
+			require.NoError(t, err)
+			require.Len(t, indexes, len(tt.expected))
+			for i, index := range indexes {
+				require.Equal(t, tt.expected[i], index.Time)
+			}
+		})
+	}
+
+	indexes, err := indexService.ListSampledIndexes(ctx, 1, opts, indexrepo.SampleOptions{Interval: 10 * time.Minute})
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	require.Equal(t, times[0], indexes[0].Time)
+}
+
+func TestSampleInvalidArgs(t *testing.T) {
+	indexService := indexrepo.New(nil, nil)
+	_, err := indexService.ListSampledIndexes(context.Background(), 10, nil, indexrepo.SampleOptions{Interval: 500 * time.Millisecond})
+	require.Error(t, err)
+	_, err = indexService.ListSampledIndexes(context.Background(), 10, &indexrepo.SearchOptions{Cursor: "abc"}, indexrepo.SampleOptions{Interval: time.Minute})
+	require.Error(t, err)
+	_, err = indexService.ListSampledIndexes(context.Background(), 10, nil, indexrepo.SampleOptions{Interval: time.Minute, Mode: indexrepo.SampleMode(42)})
+	require.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upExtrasMap, downExtrasMap) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upExtrasMap(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Existing rows are backfilled from the JSON extras column. String values are stored unquoted and
+	// other values are stored as their raw JSON, which matches what the Go insert helpers write.
+	upStatements := []string{`
+ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS extras_map Map(String, String)
+    DEFAULT CAST(
+        arrayMap(
+            x -> (tupleElement(x, 1), if(startsWith(tupleElement(x, 2), '"'), JSONExtractString(tupleElement(x, 2)), tupleElement(x, 2))),
+            JSONExtractKeysAndValuesRaw(extras)
+        ),
+        'Map(String, String)'
+    )
+    COMMENT 'Extra metadata for the cloud event as a queryable map'
+    AFTER extras;`,
+		"ALTER TABLE cloud_event MATERIALIZE COLUMN extras_map;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downExtrasMap(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS extras_map;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upLookupSkipIndexes, downLookupSkipIndexes) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upLookupSkipIndexes(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The table is ordered by subject so lookups on these columns without a subject would otherwise scan every granule.
+	upStatements := []string{
+		"ALTER TABLE cloud_event ADD INDEX IF NOT EXISTS idx_source source TYPE bloom_filter(0.01) GRANULARITY 1;",
+		"ALTER TABLE cloud_event ADD INDEX IF NOT EXISTS idx_producer producer TYPE bloom_filter(0.01) GRANULARITY 1;",
+		"ALTER TABLE cloud_event ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 1;",
+		"ALTER TABLE cloud_event ADD INDEX IF NOT EXISTS idx_index_key index_key TYPE bloom_filter(0.01) GRANULARITY 1;",
+		"ALTER TABLE cloud_event MATERIALIZE INDEX idx_source;",
+		"ALTER TABLE cloud_event MATERIALIZE INDEX idx_producer;",
+		"ALTER TABLE cloud_event MATERIALIZE INDEX idx_id;",
+		"ALTER TABLE cloud_event MATERIALIZE INDEX idx_index_key;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downLookupSkipIndexes(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"ALTER TABLE cloud_event DROP INDEX IF EXISTS idx_source;",
+		"ALTER TABLE cloud_event DROP INDEX IF EXISTS idx_producer;",
+		"ALTER TABLE cloud_event DROP INDEX IF EXISTS idx_id;",
+		"ALTER TABLE cloud_event DROP INDEX IF EXISTS idx_index_key;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
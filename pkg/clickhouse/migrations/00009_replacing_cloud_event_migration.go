@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upReplacingCloudEvent, downReplacingCloudEvent) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+// cloudEventColumnsDDL is the column and index definition of the cloud_event table shared by both engines.
+const cloudEventColumnsDDL = `
+    subject String COMMENT 'identifying the subject of the event within the context of the event producer',
+    event_time DateTime64(3, 'UTC') COMMENT 'Time at which the event occurred.',
+    event_type String COMMENT 'event type for this object',
+    id String COMMENT 'Identifier for the event.',
+    source String COMMENT 'Entity that is responsible for providing this cloud event',
+    producer String COMMENT 'specific instance, process or device that creates the data structure describing the cloud event.',
+    data_content_type String COMMENT 'Type of data of this object.',
+    data_version String COMMENT 'Version of the data stored for this cloud event.',
+    extras String COMMENT 'Extra metadata for the cloud event',
+    extras_map Map(String, String)
+        DEFAULT CAST(
+            arrayMap(
+                x -> (tupleElement(x, 1), if(startsWith(tupleElement(x, 2), '"'), JSONExtractString(tupleElement(x, 2)), tupleElement(x, 2))),
+                JSONExtractKeysAndValuesRaw(extras)
+            ),
+            'Map(String, String)'
+        )
+        COMMENT 'Extra metadata for the cloud event as a queryable map',
+    index_key String COMMENT 'Key of the backing object for this cloud event',
+    INDEX idx_source source TYPE bloom_filter(0.01) GRANULARITY 1,
+    INDEX idx_producer producer TYPE bloom_filter(0.01) GRANULARITY 1,
+    INDEX idx_id id TYPE bloom_filter(0.01) GRANULARITY 1,
+    INDEX idx_index_key index_key TYPE bloom_filter(0.01) GRANULARITY 1`
+
+func upReplacingCloudEvent(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The engine of a table can not be altered so the rows are copied into a new table which then replaces the old one.
+	// Rows with the same (subject, event_time, event_type, id, source) are collapsed to the last inserted row during merges.
+	// Rows without an ID are also keyed by their index key so distinct events in the same millisecond are kept.
+	// Rows written to the old table while the copy is running are not carried over, so stop ingestion first.
+	upStatements := []string{`
+CREATE TABLE IF NOT EXISTS cloud_event_replacing (` + cloudEventColumnsDDL + `
+) ENGINE = ReplacingMergeTree()
+ORDER BY
+    (subject, event_time, event_type, id, source, if(id = '', index_key, '')) SETTINGS index_granularity = 8192;`,
+		"INSERT INTO cloud_event_replacing SELECT * FROM cloud_event;",
+		"RENAME TABLE cloud_event TO cloud_event_mergetree, cloud_event_replacing TO cloud_event;",
+		"DROP TABLE cloud_event_mergetree;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downReplacingCloudEvent(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{`
+CREATE TABLE IF NOT EXISTS cloud_event_mergetree (` + cloudEventColumnsDDL + `
+) ENGINE = MergeTree()
+ORDER BY
+    (subject, event_time, event_type) SETTINGS index_granularity = 8192;`,
+		"INSERT INTO cloud_event_mergetree SELECT * FROM cloud_event;",
+		"RENAME TABLE cloud_event TO cloud_event_replacing, cloud_event_mergetree TO cloud_event;",
+		"DROP TABLE cloud_event_replacing;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
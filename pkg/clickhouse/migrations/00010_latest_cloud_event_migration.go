@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upLatestCloudEvent, downLatestCloudEvent) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upLatestCloudEvent(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// cloud_event_latest keeps the newest row per (subject, event_type, source, data_version).
+	// The view selects every column so columns added to both tables later are carried over without recreating it.
+	upStatements := []string{`
+CREATE TABLE IF NOT EXISTS cloud_event_latest (
+    subject String COMMENT 'identifying the subject of the event within the context of the event producer',
+    event_time DateTime64(3, 'UTC') COMMENT 'Time at which the event occurred.',
+    event_type String COMMENT 'event type for this object',
+    id String COMMENT 'Identifier for the event.',
+    source String COMMENT 'Entity that is responsible for providing this cloud event',
+    producer String COMMENT 'specific instance, process or device that creates the data structure describing the cloud event.',
+    data_content_type String COMMENT 'Type of data of this object.',
+    data_version String COMMENT 'Version of the data stored for this cloud event.',
+    extras String COMMENT 'Extra metadata for the cloud event',
+    extras_map Map(String, String) COMMENT 'Extra metadata for the cloud event as a queryable map',
+    index_key String COMMENT 'Key of the backing object for this cloud event'
+) ENGINE = ReplacingMergeTree(event_time)
+ORDER BY
+    (subject, event_type, source, data_version) SETTINGS index_granularity = 8192;`,
+		"CREATE MATERIALIZED VIEW IF NOT EXISTS cloud_event_latest_mv TO cloud_event_latest AS SELECT * FROM cloud_event;",
+		"INSERT INTO cloud_event_latest SELECT * FROM cloud_event;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downLatestCloudEvent(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"DROP VIEW IF EXISTS cloud_event_latest_mv;",
+		"DROP TABLE IF EXISTS cloud_event_latest;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
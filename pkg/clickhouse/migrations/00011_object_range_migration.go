@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upObjectRange, downObjectRange) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upObjectRange(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Batch objects hold many cloud events, each row records the byte range of its event in the object.
+	// A zero length means the row owns the whole object which is true for every existing row.
+	// The latest table is altered first so the materialized view never selects columns it does not have.
+	upStatements := []string{
+		"ALTER TABLE cloud_event_latest ADD COLUMN IF NOT EXISTS data_offset UInt64 DEFAULT 0 COMMENT 'Byte offset of the cloud event in its backing object' AFTER extras_map;",
+		"ALTER TABLE cloud_event_latest ADD COLUMN IF NOT EXISTS data_length UInt64 DEFAULT 0 COMMENT 'Byte length of the cloud event in its backing object, zero for the whole object' AFTER data_offset;",
+		"ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS data_offset UInt64 DEFAULT 0 COMMENT 'Byte offset of the cloud event in its backing object' AFTER extras_map;",
+		"ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS data_length UInt64 DEFAULT 0 COMMENT 'Byte length of the cloud event in its backing object, zero for the whole object' AFTER data_offset;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downObjectRange(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS data_length;",
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS data_offset;",
+		"ALTER TABLE cloud_event_latest DROP COLUMN IF EXISTS data_length;",
+		"ALTER TABLE cloud_event_latest DROP COLUMN IF EXISTS data_offset;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
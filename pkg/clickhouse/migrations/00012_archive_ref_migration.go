@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upArchiveRef, downArchiveRef) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upArchiveRef(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Archived cloud events live in a Parquet file, the row points at them by row group and row offset in that group.
+	// An empty format means the object or byte range holds the raw data which is true for every existing row.
+	// The latest table is altered first so the materialized view never selects columns it does not have.
+	upStatements := []string{
+		"ALTER TABLE cloud_event_latest ADD COLUMN IF NOT EXISTS data_format String DEFAULT '' COMMENT 'Format of the backing object, empty for raw data' AFTER data_length;",
+		"ALTER TABLE cloud_event_latest ADD COLUMN IF NOT EXISTS data_row_group UInt32 DEFAULT 0 COMMENT 'Row group of the cloud event in an archive object' AFTER data_format;",
+		"ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS data_format String DEFAULT '' COMMENT 'Format of the backing object, empty for raw data' AFTER data_length;",
+		"ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS data_row_group UInt32 DEFAULT 0 COMMENT 'Row group of the cloud event in an archive object' AFTER data_format;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downArchiveRef(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS data_row_group;",
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS data_format;",
+		"ALTER TABLE cloud_event_latest DROP COLUMN IF EXISTS data_row_group;",
+		"ALTER TABLE cloud_event_latest DROP COLUMN IF EXISTS data_format;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	_, filename, _, _ := runtime.Caller(0)
+	registerFunc := func() { goose.AddNamedMigrationContext(filename, upDataChecksum, downDataChecksum) }
+	registerFuncs = append(registerFuncs, registerFunc)
+}
+
+func upDataChecksum(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Existing rows get an empty checksum which is never verified.
+	// The latest table is altered first so the materialized view never selects columns it does not have.
+	upStatements := []string{
+		"ALTER TABLE cloud_event_latest ADD COLUMN IF NOT EXISTS data_checksum String DEFAULT '' COMMENT 'Hex encoded SHA-256 of the cloud event payload' AFTER data_row_group;",
+		"ALTER TABLE cloud_event ADD COLUMN IF NOT EXISTS data_checksum String DEFAULT '' COMMENT 'Hex encoded SHA-256 of the cloud event payload' AFTER data_row_group;",
+	}
+	for _, upStatement := range upStatements {
+		_, err := tx.ExecContext(ctx, upStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downDataChecksum(ctx context.Context, tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	downStatements := []string{
+		"ALTER TABLE cloud_event DROP COLUMN IF EXISTS data_checksum;",
+		"ALTER TABLE cloud_event_latest DROP COLUMN IF EXISTS data_checksum;",
+	}
+	for _, downStatement := range downStatements {
+		_, err := tx.ExecContext(ctx, downStatement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
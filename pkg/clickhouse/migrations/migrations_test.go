@@ -57,6 +57,12 @@ func TestMigration(t *testing.T) {
 		localch.DataContentTypeColumn,
 		localch.DataVersionColumn,
 		localch.ExtrasColumn,
+		localch.ExtrasMapColumn,
+		localch.DataOffsetColumn,
+		localch.DataLengthColumn,
+		localch.DataFormatColumn,
+		localch.DataRowGroupColumn,
+		localch.DataChecksumColumn,
 		localch.IndexKeyColumn,
 	}
 	assert.ElementsMatch(t, expectedCols, cols, "Columns do not match")
@@ -68,8 +74,16 @@ func TestMigration(t *testing.T) {
 		localch.SubjectColumn,
 		localch.TimestampColumn,
 		localch.TypeColumn,
+		localch.IDColumn,
+		localch.SourceColumn,
+		"if(" + localch.IDColumn + " = '', " + localch.IndexKeyColumn + ", '')",
 	}
 	assert.ElementsMatch(t, expectedOrderByCols, orderByCols, "Order by columns do not match")
+
+	// Check the live schema has no drift from the expected columns
+	drifts, err := localch.CheckSchema(ctx, conn)
+	require.NoError(t, err, "Failed to check schema")
+	assert.Empty(t, drifts, "Schema drift detected")
 	// Close the DB connection
 	err = db.Close()
 	assert.NoError(t, err, "Failed to close DB connection")
@@ -85,7 +99,21 @@ func getOrderByCols(ctx context.Context, conn clickhouse.Conn, tableName string)
 	if err != nil {
 		return nil, err
 	}
-	return strings.Split(sortingKey, ", "), nil
+	// split on the commas between the expressions, not on the commas inside function calls
+	var cols []string
+	var depth, start int
+	for i, r := range sortingKey {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			cols = append(cols, strings.TrimSpace(sortingKey[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cols, strings.TrimSpace(sortingKey[start:])), nil
 }
 
 func insertIndex(conn clickhouse.Conn, hdr cloudevent.CloudEventHeader) error {
@@ -0,0 +1,79 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes how long cloud events of a single type are kept.
+// Event types without a policy are kept forever.
+type RetentionPolicy struct {
+	// EventType is the cloud event type the policy applies to.
+	EventType string
+	// Retention is how long events of this type are kept after their event time.
+	Retention time.Duration
+}
+
+// ParseRetentionPolicy parses a policy in the form <event_type>=<duration>, eg dimo.status=2160h.
+func ParseRetentionPolicy(value string) (RetentionPolicy, error) {
+	eventType, durationStr, ok := strings.Cut(value, "=")
+	if !ok {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention policy %q, expected <event_type>=<duration>", value)
+	}
+	retention, err := time.ParseDuration(durationStr)
+	if err != nil {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention for %q: %w", eventType, err)
+	}
+	policy := RetentionPolicy{EventType: eventType, Retention: retention}
+	if err := policy.Validate(); err != nil {
+		return RetentionPolicy{}, err
+	}
+	return policy, nil
+}
+
+// Validate returns an error if the policy can not be applied.
+func (p RetentionPolicy) Validate() error {
+	if p.EventType == "" {
+		return fmt.Errorf("retention policy event type is empty")
+	}
+	if p.Retention < time.Second {
+		return fmt.Errorf("retention for %q must be at least one second", p.EventType)
+	}
+	return nil
+}
+
+// RetentionTTLStmt returns the statement that applies the retention policies as TTL rules to the given table.
+// Rows expire grace after the retention of their type so that the objects they point to can be deleted first.
+// If no policies are given the statement removes the TTL from the table.
+func RetentionTTLStmt(tableName string, policies []RetentionPolicy, grace time.Duration) (string, error) {
+	if grace < 0 {
+		return "", fmt.Errorf("grace must not be negative")
+	}
+	if len(policies) == 0 {
+		return "ALTER TABLE " + tableName + " REMOVE TTL", nil
+	}
+	seen := make(map[string]struct{}, len(policies))
+	rules := make([]string, len(policies))
+	for i, policy := range policies {
+		if err := policy.Validate(); err != nil {
+			return "", err
+		}
+		if _, ok := seen[policy.EventType]; ok {
+			return "", fmt.Errorf("duplicate retention policy for %q", policy.EventType)
+		}
+		seen[policy.EventType] = struct{}{}
+		seconds := int64((policy.Retention + grace) / time.Second)
+		rules[i] = "toDateTime(" + TimestampColumn + ") + toIntervalSecond(" + strconv.FormatInt(seconds, 10) + ")" +
+			" DELETE WHERE " + TypeColumn + " = " + quoteString(policy.EventType)
+	}
+	return "ALTER TABLE " + tableName + " MODIFY TTL " + strings.Join(rules, ", "), nil
+}
+
+// quoteString returns s as a ClickHouse string literal.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
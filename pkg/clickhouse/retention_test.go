@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionTTLStmt(t *testing.T) {
+	tests := []struct {
+		name          string
+		policies      []RetentionPolicy
+		grace         time.Duration
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "no policies removes ttl",
+			expected: "ALTER TABLE cloud_event REMOVE TTL",
+		},
+		{
+			name: "multiple policies",
+			policies: []RetentionPolicy{
+				{EventType: "dimo.status", Retention: 90 * 24 * time.Hour},
+				{EventType: "dimo.fingerprint", Retention: time.Hour},
+			},
+			grace: time.Hour,
+			expected: "ALTER TABLE cloud_event MODIFY TTL " +
+				"toDateTime(event_time) + toIntervalSecond(7779600) DELETE WHERE event_type = 'dimo.status', " +
+				"toDateTime(event_time) + toIntervalSecond(7200) DELETE WHERE event_type = 'dimo.fingerprint'",
+		},
+		{
+			name:     "event type is escaped",
+			policies: []RetentionPolicy{{EventType: `it's`, Retention: time.Second}},
+			expected: `ALTER TABLE cloud_event MODIFY TTL toDateTime(event_time) + toIntervalSecond(1) DELETE WHERE event_type = 'it\'s'`,
+		},
+		{
+			name: "duplicate policy",
+			policies: []RetentionPolicy{
+				{EventType: "dimo.status", Retention: time.Hour},
+				{EventType: "dimo.status", Retention: 2 * time.Hour},
+			},
+			expectedError: true,
+		},
+		{
+			name:          "zero retention",
+			policies:      []RetentionPolicy{{EventType: "dimo.status"}},
+			expectedError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := RetentionTTLStmt(TableName, tt.policies, tt.grace)
+			if tt.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, stmt)
+		})
+	}
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	policy, err := ParseRetentionPolicy("dimo.status=2160h")
+	require.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{EventType: "dimo.status", Retention: 2160 * time.Hour}, policy)
+
+	_, err = ParseRetentionPolicy("dimo.status")
+	require.Error(t, err)
+	_, err = ParseRetentionPolicy("dimo.status=forever")
+	require.Error(t, err)
+}
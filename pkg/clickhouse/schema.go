@@ -0,0 +1,159 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// Column describes a single column of the cloud event table.
+type Column struct {
+	// Name is the name of the column.
+	Name string
+	// Type is the ClickHouse type of the column as reported by system.columns.
+	Type string
+}
+
+// Columns is the expected layout of the cloud event table.
+// The order of the elements matches the order of the columns in the table.
+var Columns = []Column{
+	{Name: SubjectColumn, Type: "String"},
+	{Name: TimestampColumn, Type: "DateTime64(3, 'UTC')"},
+	{Name: TypeColumn, Type: "String"},
+	{Name: IDColumn, Type: "String"},
+	{Name: SourceColumn, Type: "String"},
+	{Name: ProducerColumn, Type: "String"},
+	{Name: DataContentTypeColumn, Type: "String"},
+	{Name: DataVersionColumn, Type: "String"},
+	{Name: ExtrasColumn, Type: "String"},
+	{Name: ExtrasMapColumn, Type: "Map(String, String)"},
+	{Name: DataOffsetColumn, Type: "UInt64"},
+	{Name: DataLengthColumn, Type: "UInt64"},
+	{Name: DataFormatColumn, Type: "String"},
+	{Name: DataRowGroupColumn, Type: "UInt32"},
+	{Name: DataChecksumColumn, Type: "String"},
+	{Name: IndexKeyColumn, Type: "String"},
+}
+
+// DriftKind is the kind of difference found between the expected and the live table schema.
+type DriftKind string
+
+const (
+	// DriftMissing means an expected column does not exist in the table.
+	DriftMissing DriftKind = "missing"
+	// DriftUnexpected means the table has a column that is not expected.
+	DriftUnexpected DriftKind = "unexpected"
+	// DriftType means the column exists but has a different type.
+	DriftType DriftKind = "type"
+	// DriftPosition means the column exists but is at a different position.
+	DriftPosition DriftKind = "position"
+)
+
+// ColumnDrift describes a single difference between the expected and the live table schema.
+type ColumnDrift struct {
+	// Column is the name of the drifted column.
+	Column string
+	// Kind is the kind of drift.
+	Kind DriftKind
+	// Expected is the expected value, empty for unexpected columns.
+	Expected string
+	// Actual is the live value, empty for missing columns.
+	Actual string
+}
+
+// String returns a human readable description of the drift.
+func (d ColumnDrift) String() string {
+	switch d.Kind {
+	case DriftMissing:
+		return fmt.Sprintf("column %s is missing, expected type %s", d.Column, d.Expected)
+	case DriftUnexpected:
+		return fmt.Sprintf("column %s of type %s is not expected", d.Column, d.Actual)
+	default:
+		return fmt.Sprintf("column %s has %s %s, expected %s", d.Column, d.Kind, d.Actual, d.Expected)
+	}
+}
+
+// CheckSchema compares the live cloud event table with the expected Columns.
+// An empty result means the table matches what the Go code expects.
+func CheckSchema(ctx context.Context, conn clickhouse.Conn) ([]ColumnDrift, error) {
+	actual, err := GetTableColumns(ctx, conn, TableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(actual) == 0 {
+		return nil, fmt.Errorf("table %s does not exist or has no columns", TableName)
+	}
+	return DiffColumns(Columns, actual), nil
+}
+
+// GetTableColumns returns the columns of the given table in the current database ordered by position.
+func GetTableColumns(ctx context.Context, conn clickhouse.Conn, tableName string) ([]Column, error) {
+	selectStmt := "SELECT name, type FROM system.columns WHERE database = currentDatabase() AND table = ? ORDER BY position"
+	rows, err := conn.Query(ctx, selectStmt, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table columns: %w", err)
+	}
+	defer rows.Close() //nolint // we are not interested in the error here
+	var cols []Column
+	for rows.Next() {
+		var col Column
+		if err := rows.Scan(&col.Name, &col.Type); err != nil {
+			return nil, fmt.Errorf("failed to scan table column: %w", err)
+		}
+		cols = append(cols, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over table columns: %w", err)
+	}
+	return cols, nil
+}
+
+// DiffColumns returns the differences between the expected and actual columns.
+// Positions are compared only among the columns present in both lists
+// so that a single missing column is not reported as every later column moving.
+func DiffColumns(expected, actual []Column) []ColumnDrift {
+	actualByName := make(map[string]Column, len(actual))
+	for _, col := range actual {
+		actualByName[col.Name] = col
+	}
+	expectedByName := make(map[string]Column, len(expected))
+	for _, col := range expected {
+		expectedByName[col.Name] = col
+	}
+
+	var drifts []ColumnDrift
+	var expectedCommon []string
+	for _, col := range expected {
+		actualCol, ok := actualByName[col.Name]
+		if !ok {
+			drifts = append(drifts, ColumnDrift{Column: col.Name, Kind: DriftMissing, Expected: col.Type})
+			continue
+		}
+		expectedCommon = append(expectedCommon, col.Name)
+		if actualCol.Type != col.Type {
+			drifts = append(drifts, ColumnDrift{Column: col.Name, Kind: DriftType, Expected: col.Type, Actual: actualCol.Type})
+		}
+	}
+
+	var actualCommon []string
+	for _, col := range actual {
+		if _, ok := expectedByName[col.Name]; !ok {
+			drifts = append(drifts, ColumnDrift{Column: col.Name, Kind: DriftUnexpected, Actual: col.Type})
+			continue
+		}
+		actualCommon = append(actualCommon, col.Name)
+	}
+
+	actualPos := make(map[string]int, len(actualCommon))
+	for i, name := range actualCommon {
+		actualPos[name] = i
+	}
+	for i, name := range expectedCommon {
+		if pos := actualPos[name]; pos != i {
+			drifts = append(drifts, ColumnDrift{Column: name, Kind: DriftPosition, Expected: strconv.Itoa(i + 1), Actual: strconv.Itoa(pos + 1)})
+		}
+	}
+	return drifts
+}
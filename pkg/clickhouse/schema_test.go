@@ -0,0 +1,88 @@
+package clickhouse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffColumns(t *testing.T) {
+	expected := []Column{
+		{Name: "a", Type: "String"},
+		{Name: "b", Type: "DateTime64(3, 'UTC')"},
+		{Name: "c", Type: "String"},
+	}
+
+	tests := []struct {
+		name     string
+		actual   []Column
+		expected []ColumnDrift
+	}{
+		{
+			name:   "no drift",
+			actual: expected,
+		},
+		{
+			name: "missing column does not shift positions",
+			actual: []Column{
+				{Name: "a", Type: "String"},
+				{Name: "c", Type: "String"},
+			},
+			expected: []ColumnDrift{
+				{Column: "b", Kind: DriftMissing, Expected: "DateTime64(3, 'UTC')"},
+			},
+		},
+		{
+			name: "unexpected column",
+			actual: []Column{
+				{Name: "a", Type: "String"},
+				{Name: "b", Type: "DateTime64(3, 'UTC')"},
+				{Name: "c", Type: "String"},
+				{Name: "d", Type: "UInt64"},
+			},
+			expected: []ColumnDrift{
+				{Column: "d", Kind: DriftUnexpected, Actual: "UInt64"},
+			},
+		},
+		{
+			name: "type mismatch",
+			actual: []Column{
+				{Name: "a", Type: "LowCardinality(String)"},
+				{Name: "b", Type: "DateTime64(3, 'UTC')"},
+				{Name: "c", Type: "String"},
+			},
+			expected: []ColumnDrift{
+				{Column: "a", Kind: DriftType, Expected: "String", Actual: "LowCardinality(String)"},
+			},
+		},
+		{
+			name: "swapped columns",
+			actual: []Column{
+				{Name: "a", Type: "String"},
+				{Name: "c", Type: "String"},
+				{Name: "b", Type: "DateTime64(3, 'UTC')"},
+			},
+			expected: []ColumnDrift{
+				{Column: "b", Kind: DriftPosition, Expected: "2", Actual: "3"},
+				{Column: "c", Kind: DriftPosition, Expected: "3", Actual: "2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DiffColumns(expected, tt.actual))
+		})
+	}
+}
+
+func TestColumnsMatchInsertStmt(t *testing.T) {
+	names := make([]string, len(Columns))
+	for i, col := range Columns {
+		names[i] = col.Name
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	stmt := "INSERT INTO " + TableName + " (" + strings.Join(names, ", ") + ") VALUES (" + placeholders + ")"
+	assert.Equal(t, stmt, InsertStmt)
+}